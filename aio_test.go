@@ -0,0 +1,571 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+
+package aio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/pedroalbanese/brotli"
+)
+
+// sampleInputs returns inputs of different sizes and compressibility.
+func sampleInputs() [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	noise := make([]byte, 4<<10)
+	rnd.Read(noise)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 64<<10; i++ {
+		fmt.Fprintf(&text, "line %d of the sample, %x\n", i, noise[i%len(noise)])
+	}
+	return [][]byte{nil, []byte("a"), []byte("hello, hello, hello, world\n"), noise, text.Bytes()}
+}
+
+func TestAlgorithms(t *testing.T) {
+	want := []string{"brotli", "bzip2", "gzip", "lzma", "s2", "snappy", "xz", "zlib", "zstd"}
+	if got := Algorithms(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Algorithms() = %v, want %v", got, want)
+	}
+	if _, err := Compress("lz4", DefaultLevel, nil); err != ErrUnknownAlgorithm {
+		t.Errorf("Compress(lz4) = %v, want ErrUnknownAlgorithm", err)
+	}
+	if _, err := Decompress("lz4", nil); err != ErrUnknownAlgorithm {
+		t.Errorf("Decompress(lz4) = %v, want ErrUnknownAlgorithm", err)
+	}
+}
+
+// nopWriteCloser is an io.Writer with a Close method that does nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestRegister(t *testing.T) {
+	defer delete(codecs, "identity")
+	Register(Codec{
+		Name:   "identity",
+		Suffix: "id",
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		},
+	})
+	data, err := Compress("identity", DefaultLevel, []byte("as is"))
+	if err != nil || string(data) != "as is" {
+		t.Fatalf("Compress(identity) = %q, %v, want the input", data, err)
+	}
+	if out, err := Decompress("identity", data); err != nil || string(out) != "as is" {
+		t.Errorf("Decompress(identity) = %q, %v, want the input", out, err)
+	}
+	if c, ok := Lookup("identity"); ok == false || c.Suffix != "id" {
+		t.Errorf("Lookup(identity) = %+v, %v, want the registered codec", c, ok)
+	}
+}
+
+// levels returns the levels of c worth testing: the default and the bounds.
+func levels(c Codec) []int {
+	if c.MaxLevel == 0 {
+		return []int{DefaultLevel}
+	}
+	return []int{DefaultLevel, c.MinLevel, c.MaxLevel}
+}
+
+// TestRoundTrip compresses the samples with every algorithm at its default
+// and extreme levels, and decompresses them naming the algorithm and
+// detecting it.
+func TestRoundTrip(t *testing.T) {
+	for _, algo := range Algorithms() {
+		c, _ := Lookup(algo)
+		for _, level := range levels(c) {
+			for _, in := range sampleInputs() {
+				data, err := Compress(algo, level, in)
+				if err != nil {
+					t.Fatalf("Compress(%s, %d, %d bytes): %v", algo, level, len(in), err)
+				}
+				out, err := Decompress(algo, data)
+				if err != nil || bytes.Equal(out, in) == false {
+					t.Errorf("%s level %d: %d bytes decompressed to %d bytes, %v", algo, level, len(in), len(out), err)
+				}
+				got, r, err := DetectAlgorithm(bytes.NewReader(data))
+				if algo == "brotli" {
+					if err != ErrUnknownFormat {
+						t.Errorf("DetectAlgorithm(brotli) = %q, %v, want ErrUnknownFormat", got, err)
+					}
+					continue
+				}
+				if err != nil || got != algo {
+					t.Fatalf("DetectAlgorithm(%s level %d) = %q, %v", algo, level, got, err)
+				}
+				out, err = ioutil.ReadAll(r)
+				r.Close()
+				if err != nil || bytes.Equal(out, in) == false {
+					t.Errorf("%s level %d detected: %d bytes decompressed to %d bytes, %v", algo, level, len(in), len(out), err)
+				}
+			}
+		}
+	}
+}
+
+// TestEmpty closes the codec writers without writing anything, and checks
+// that the output is a stream that decodes to nothing, by name and, but
+// for brotli, detected.
+func TestEmpty(t *testing.T) {
+	for _, algo := range Algorithms() {
+		c, _ := Lookup(algo)
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf, DefaultLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if buf.Len() == 0 {
+			t.Errorf("%s: an empty input compresses to nothing", algo)
+		}
+		names := []string{algo, ""}
+		if algo == "brotli" {
+			names = names[:1]
+		}
+		for _, name := range names {
+			if out, err := Decompress(name, buf.Bytes()); err != nil || len(out) != 0 {
+				t.Errorf("Decompress(%q) of an empty %s stream = %q, %v", name, algo, out, err)
+			}
+		}
+	}
+}
+
+// readAll reads the whole of the decompressed stream of data with algo,
+// closing the reader, and returns the first error of the three steps.
+func readAll(algo string, data []byte) ([]byte, error) {
+	r, err := DecompressReader(algo, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	return out, err
+}
+
+// TestDecompressCorrupt checks that a truncated stream of every algorithm,
+// and a corrupt one of those with a checksum, makes Read or Close fail
+// rather than end early or with the wrong data.
+func TestDecompressCorrupt(t *testing.T) {
+	in := sampleInputs()[4]
+	for _, algo := range Algorithms() {
+		data, err := Compress(algo, DefaultLevel, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{len(data) / 2, len(data) - 1} {
+			if out, err := readAll(algo, data[:n]); err == nil {
+				t.Errorf("%s truncated to %d of %d bytes: %d bytes decompressed without an error", algo, n, len(data), len(out))
+			}
+		}
+		// Brotli and lzma streams carry no checksum, so a changed byte
+		// may decode to other data.
+		if algo == "brotli" || algo == "lzma" {
+			continue
+		}
+		// The middle of the data, and the trailer of the formats ending
+		// in a checksum.
+		at := []int{len(data) / 2}
+		if algo != "s2" && algo != "snappy" {
+			at = append(at, len(data)-3)
+		}
+		for _, i := range at {
+			bad := append([]byte(nil), data...)
+			bad[i] ^= 0x55
+			if out, err := readAll(algo, bad); err == nil {
+				t.Errorf("%s corrupt at byte %d of %d: %d bytes decompressed without an error", algo, i, len(data), len(out))
+			}
+		}
+	}
+}
+
+// TestLevelOutOfRange checks that a level outside the bounds of an
+// algorithm is an error rather than a panic, or a silent clamp.
+func TestLevelOutOfRange(t *testing.T) {
+	for _, algo := range Algorithms() {
+		c, _ := Lookup(algo)
+		if c.MaxLevel == 0 {
+			continue
+		}
+		for _, level := range []int{-2, c.MinLevel - 1, c.MaxLevel + 1, 100} {
+			if level == DefaultLevel {
+				continue
+			}
+			if _, err := Compress(algo, level, []byte("hello")); err == nil {
+				t.Errorf("Compress(%s, %d) succeeded, want an error", algo, level)
+			}
+		}
+	}
+}
+
+// TestStream writes through the codec writers in small pieces and reads
+// back through DecompressReader in small pieces too.
+func TestStream(t *testing.T) {
+	in := sampleInputs()[4]
+	for _, algo := range Algorithms() {
+		c, _ := Lookup(algo)
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf, DefaultLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := in; len(p) > 0; p = p[minInt(len(p), 1000):] {
+			if _, err := w.Write(p[:minInt(len(p), 1000)]); err != nil {
+				t.Fatalf("%s: %v", algo, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		r, err := DecompressReader(algo, &buf)
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		out, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		r.Close()
+		if err != nil || bytes.Equal(out, in) == false {
+			t.Errorf("%s: %d bytes decompressed to %d bytes, %v", algo, len(in), len(out), err)
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func BenchmarkCompress(b *testing.B) {
+	in := sampleInputs()[4]
+	for _, algo := range Algorithms() {
+		b.Run(algo, func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Compress(algo, DefaultLevel, in); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	in := sampleInputs()[4]
+	for _, algo := range Algorithms() {
+		data, err := Compress(algo, DefaultLevel, in)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(algo, func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Decompress(algo, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestConcatenated decodes two members of each format whose streams may
+// be concatenated, as bzip2 -d and xz -d do.
+func TestConcatenated(t *testing.T) {
+	first, second := sampleInputs()[4], []byte("the second member\n")
+	for _, algo := range []string{"bzip2", "gzip", "s2", "snappy", "xz", "zstd"} {
+		a, err := Compress(algo, DefaultLevel, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Compress(algo, DefaultLevel, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append(append([]byte(nil), first...), second...)
+		for _, name := range []string{algo, ""} {
+			out, err := Decompress(name, append(append([]byte(nil), a...), b...))
+			if err != nil || bytes.Equal(out, want) == false {
+				t.Errorf("Decompress(%q) of two %s members = %d bytes, %v, want %d", name, algo, len(out), err, len(want))
+			}
+		}
+	}
+}
+
+// snappyCRC returns the masked CRC-32C of data, as snappy frames
+// store it.
+func snappyCRC(data []byte) uint32 {
+	c := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// decodeSnappyFrames decodes a stream in the snappy framing format as
+// the reference implementation defines it, without the s2 extensions,
+// to check that snappy streams are readable by other snappy tools.
+func decodeSnappyFrames(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, magicSnappy) == false {
+		return nil, errors.New("no snappy stream identifier")
+	}
+	var out []byte
+	for data = data[len(magicSnappy):]; len(data) > 0; {
+		if len(data) < 4 {
+			return nil, errors.New("truncated chunk header")
+		}
+		typ, n := data[0], int(data[1])|int(data[2])<<8|int(data[3])<<16
+		if len(data) < 4+n {
+			return nil, errors.New("truncated chunk")
+		}
+		chunk := data[4 : 4+n]
+		data = data[4+n:]
+		switch {
+		case typ == 0x00 || typ == 0x01:
+			if n < 4 {
+				return nil, errors.New("chunk without a checksum")
+			}
+			body := chunk[4:]
+			if typ == 0x00 {
+				var err error
+				if body, err = decodeSnappyBlock(body); err != nil {
+					return nil, err
+				}
+			}
+			if snappyCRC(body) != binary.LittleEndian.Uint32(chunk) {
+				return nil, errors.New("checksum mismatch")
+			}
+			out = append(out, body...)
+		case typ == 0xff:
+			if bytes.Equal(chunk, magicSnappy[4:]) == false {
+				return nil, errors.New("bad stream identifier")
+			}
+		case typ < 0x80:
+			return nil, fmt.Errorf("reserved unskippable chunk %#x", typ)
+		}
+	}
+	return out, nil
+}
+
+// decodeSnappyBlock decodes a snappy block of literals and copies with
+// 1, 2 and 4 byte offsets.
+func decodeSnappyBlock(src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errors.New("bad block length")
+	}
+	var dst []byte
+	for src = src[k:]; len(src) > 0; {
+		tag := src[0]
+		var length, offset, n int
+		switch tag & 3 {
+		case 0:
+			length, n = int(tag>>2)+1, 1
+			if length > 60 {
+				n += length - 60
+				if len(src) < n {
+					return nil, errors.New("truncated literal length")
+				}
+				length = 0
+				for i := n - 1; i > 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				length++
+			}
+			if len(src) < n+length {
+				return nil, errors.New("truncated literal")
+			}
+			dst = append(dst, src[n:n+length]...)
+			src = src[n+length:]
+			continue
+		case 1:
+			length, n = 4+int(tag>>2&7), 2
+			if len(src) >= n {
+				offset = int(tag>>5)<<8 | int(src[1])
+			}
+		case 2:
+			length, n = 1+int(tag>>2), 3
+			if len(src) >= n {
+				offset = int(binary.LittleEndian.Uint16(src[1:]))
+			}
+		case 3:
+			length, n = 1+int(tag>>2), 5
+			if len(src) >= n {
+				offset = int(binary.LittleEndian.Uint32(src[1:]))
+			}
+		}
+		if len(src) < n {
+			return nil, errors.New("truncated copy")
+		}
+		src = src[n:]
+		// s2 repeats the previous offset with a zero one, which snappy
+		// doesn't have.
+		if offset <= 0 || offset > len(dst) {
+			return nil, fmt.Errorf("bad copy offset %d", offset)
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errors.New("block length mismatch")
+	}
+	return dst, nil
+}
+
+// snappyChunk frames body as a chunk of typ holding data.
+func snappyChunk(typ byte, data, body []byte) []byte {
+	h := []byte{typ, 0, 0, 0, 0, 0, 0, 0}
+	n := 4 + len(body)
+	h[1], h[2], h[3] = byte(n), byte(n>>8), byte(n>>16)
+	binary.LittleEndian.PutUint32(h[4:], snappyCRC(data))
+	return append(h, body...)
+}
+
+func TestSnappyReference(t *testing.T) {
+	for _, in := range sampleInputs() {
+		data, err := Compress("snappy", DefaultLevel, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := decodeSnappyFrames(data)
+		if err != nil || bytes.Equal(out, in) == false {
+			t.Errorf("%d bytes compressed with snappy decode to %d bytes as reference snappy, %v", len(in), len(out), err)
+		}
+	}
+
+	// A stream as the reference encoder writes it: an uncompressed chunk,
+	// then a compressed one of a literal and a copy.
+	stream := append([]byte(nil), magicSnappy...)
+	stream = append(stream, snappyChunk(0x01, []byte("hello, "), []byte("hello, "))...)
+	stream = append(stream, snappyChunk(0x00, []byte("abcabcabc"), []byte{9, 2 << 2, 'a', 'b', 'c', 2<<2 | 1, 3})...)
+	stream = append(stream, 0xfe, 2, 0, 0, 0, 0)
+	if out, err := decodeSnappyFrames(stream); err != nil || string(out) != "hello, abcabcabc" {
+		t.Fatalf("the reference stream decodes to %q, %v with the test decoder", out, err)
+	}
+	for _, algo := range []string{"snappy", ""} {
+		out, err := Decompress(algo, stream)
+		if err != nil || string(out) != "hello, abcabcabc" {
+			t.Errorf("Decompress(%q) of the reference stream = %q, %v", algo, out, err)
+		}
+	}
+
+	// s2 streams are a superset, and carry an identifier of their own.
+	if data, _ := Compress("s2", DefaultLevel, sampleInputs()[4]); Identify(data) != "s2" {
+		t.Errorf("an s2 stream is identified as %q", Identify(data))
+	}
+}
+
+// fuzzLimit bounds the output read from a mutated stream, which may
+// declare any size.
+const fuzzLimit = 16 << 20
+
+// mutate returns a copy of data with a few random changes: flipped bytes,
+// a truncation, inserted garbage or a repeated chunk.
+func mutate(rnd *rand.Rand, data []byte) []byte {
+	out := append([]byte(nil), data...)
+	for n := 1 + rnd.Intn(3); n > 0; n-- {
+		switch op := rnd.Intn(4); {
+		case op == 0 && len(out) > 0:
+			out[rnd.Intn(len(out))] ^= byte(1 + rnd.Intn(255))
+		case op == 1 && len(out) > 0:
+			out = out[:rnd.Intn(len(out))]
+		case op == 2:
+			i := rnd.Intn(len(out) + 1)
+			garbage := make([]byte, 1+rnd.Intn(16))
+			rnd.Read(garbage)
+			out = append(out[:i], append(garbage, out[i:]...)...)
+		case op == 3 && len(out) > 0:
+			i := rnd.Intn(len(out))
+			j := i + rnd.Intn(len(out)-i)
+			out = append(out[:j], append(append([]byte(nil), out[i:j]...), out[j:]...)...)
+		}
+	}
+	return out
+}
+
+// decodeMutated decodes data with algo, or with detection when algo is
+// "", and fails the test if the decoder panics. Errors are expected.
+func decodeMutated(t *testing.T, algo string, data []byte) {
+	defer func() {
+		if p := recover(); p != nil {
+			t.Fatalf("%q decoding %d bytes panicked: %v\ninput: %x", algo, len(data), p, data)
+		}
+	}()
+	r, err := DecompressReader(algo, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(r, fuzzLimit))
+	r.Close()
+}
+
+// TestDecompressMutated feeds randomly mutated streams of every algorithm
+// to the decoders, naming the algorithm and detecting it, and checks that
+// they return errors rather than panic.
+func TestDecompressMutated(t *testing.T) {
+	iterations := 300
+	if testing.Short() {
+		iterations = 30
+	}
+	for _, algo := range Algorithms() {
+		t.Run(algo, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(int64(len(algo))))
+			for _, in := range sampleInputs() {
+				seed, err := Compress(algo, DefaultLevel, in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < iterations; i++ {
+					data := mutate(rnd, seed)
+					decodeMutated(t, algo, data)
+					decodeMutated(t, "", data)
+				}
+			}
+		})
+	}
+}
+
+// TestDecompressRandom feeds random bytes to every decoder, and to the
+// detection after each magic number.
+func TestDecompressRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	magics := [][]byte{nil, magicGzip, magicBzip2, magicXz, magicZstd, magicS2, magicSnappy, {0x78, 0x9c}, {0x5d, 0, 0, 0x80, 0}}
+	for i := 0; i < 200; i++ {
+		data := make([]byte, rnd.Intn(512))
+		rnd.Read(data)
+		for _, algo := range Algorithms() {
+			decodeMutated(t, algo, data)
+		}
+		for _, m := range magics {
+			decodeMutated(t, "", append(append([]byte(nil), m...), data...))
+		}
+	}
+}
+
+// TestBrotliExcessiveInput checks that the brotli library still rejects
+// data after the end of a stream with the message brotliReader compares
+// against to tell a complete stream from a truncated one.
+func TestBrotliExcessiveInput(t *testing.T) {
+	data, err := Compress("brotli", DefaultLevel, []byte("complete\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(append(data, 0))))
+	if err == nil || err.Error() != brotliExcessiveInput {
+		t.Errorf("reading a brotli stream with a byte after it: %v, want %q", err, brotliExcessiveInput)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// parseMode parses the octal permission bits given to -chmod.
+func parseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode %s, want octal permission bits such as 0644", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// parseOwner parses the user[:group] given to -chown, as names or
+// numeric ids. Either part may be left empty, and -1 is returned for it.
+func parseOwner(s string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	parts := strings.SplitN(s, ":", 2)
+	if parts[0] != "" {
+		if uid, err = lookupID(parts[0], true); err != nil {
+			return -1, -1, err
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		if gid, err = lookupID(parts[1], false); err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+func lookupID(name string, isUser bool) (int, error) {
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		return n, nil
+	}
+	var id string
+	if isUser == true {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return -1, err
+		}
+		id = u.Uid
+	} else {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return -1, err
+		}
+		id = g.Gid
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return -1, fmt.Errorf("%s has no numeric id", name)
+	}
+	return n, nil
+}
+
+// setAttrs applies -chmod and -chown to the output written at p, each
+// of its volumes with -split, and its sidecar checksum.
+func setAttrs(p string) error {
+	list := []string{p}
+	if *split != "" {
+		list = volumes(p)
+	}
+	if *checksum != "" && *decompress == false {
+		list = append(list, p+"."+*checksum)
+	}
+	for _, f := range list {
+		if *chmod != "" {
+			if err := os.Chmod(f, outMode); err != nil {
+				return err
+			}
+		}
+		if *chown != "" {
+			if err := os.Chown(f, outUID, outGID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
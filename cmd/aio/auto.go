@@ -0,0 +1,175 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// autoSampleSize is the amount of input -a auto looks at.
+	autoSampleSize = 64 << 10
+	// autoTinySize is the input size below which -a auto picks gzip,
+	// whose header is the smallest.
+	autoTinySize = 4 << 10
+	// autoEntropy is the entropy, in bits per byte, at or above which
+	// -a auto considers the input incompressible and picks s2.
+	autoEntropy = 7.5
+)
+
+// autoSample is the start of standard input, read by -a auto to pick the
+// algorithm, which is compressed ahead of the rest.
+var autoSample []byte
+
+// autoPicked is set once -a auto has replaced the algorithm, for
+// -summary to report it.
+var autoPicked bool
+
+// autoDryRun is how -dry-run reports the algorithm -a auto would pick.
+const autoDryRun = "the algorithm auto picks from a sample of the input"
+
+// autoAlgorithm picks the algorithm of -a auto for an input of size bytes
+// whose entropy is bits per byte: gzip for tiny inputs, s2 for
+// incompressible ones and zstd for the rest.
+func autoAlgorithm(bits float64, size int64) string {
+	switch {
+	case size < autoTinySize:
+		return "gzip"
+	case bits >= autoEntropy:
+		return "s2"
+	}
+	return "zstd"
+}
+
+// byteHistogram counts the occurrences of each byte value.
+type byteHistogram struct {
+	counts [256]int64
+	total  int64
+}
+
+func (h *byteHistogram) Write(p []byte) (int, error) {
+	for _, b := range p {
+		h.counts[b]++
+	}
+	h.total += int64(len(p))
+	return len(p), nil
+}
+
+// entropy returns the Shannon entropy of the counted bytes in bits per
+// byte, from 0 for a single repeated value to 8 for uniform noise.
+func (h *byteHistogram) entropy() float64 {
+	var e float64
+	for _, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		f := float64(c) / float64(h.total)
+		e -= f * math.Log2(f)
+	}
+	return e
+}
+
+// sampleEntropy returns the entropy of p in bits per byte.
+func sampleEntropy(p []byte) float64 {
+	var h byteHistogram
+	h.Write(p)
+	return h.entropy()
+}
+
+// resolveAuto replaces -a auto with the algorithm picked for the input
+// file p, or standard input when stdin is set.
+func resolveAuto(p string) {
+	autoPicked = true
+	if stdin == true {
+		var err error
+		autoSample, err = ioutil.ReadAll(io.LimitReader(os.Stdin, autoSampleSize))
+		if err != nil {
+			fatal(err.Error())
+		}
+		size := int64(len(autoSample))
+		if size == autoSampleSize {
+			size = math.MaxInt64
+		}
+		*algorithm = autoAlgorithm(sampleEntropy(autoSample), size)
+		if *optimize != "" && size >= autoTinySize {
+			*algorithm = optimizeAlgorithm(autoSample)
+		}
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		fatal(err.Error())
+	}
+	if fi.Mode().IsRegular() == false {
+		exit(fmt.Sprintf("%s is not a regular file, auto can't read ahead of it", p))
+	}
+	sample, err := ioutil.ReadAll(io.LimitReader(f, autoSampleSize))
+	if err != nil {
+		fatal(err.Error())
+	}
+	*algorithm = autoAlgorithm(sampleEntropy(sample), fi.Size())
+	if *optimize != "" && fi.Size() >= autoTinySize {
+		*algorithm = optimizeAlgorithm(sample)
+	}
+}
+
+// optimizeAlgorithm compresses sample with every algorithm, at the level
+// -optimize selects, and returns the smallest output for size, the fastest
+// for speed, and for balanced the smallest of the faster half. Algorithms
+// that don't shrink the sample only count when none does, s2 being picked
+// then. Compressing the sample that many times costs about as much as a
+// single pass over a few MiB, on top of the compression itself.
+func optimizeAlgorithm(sample []byte) string {
+	type trial struct {
+		name string
+		size int
+		time time.Duration
+	}
+	var trials []trial
+	for _, name := range codecNames() {
+		var buf bytes.Buffer
+		start := time.Now()
+		z, err := newCompressor(&buf, name, 0)
+		if err == nil {
+			if _, err = z.Write(sample); err == nil {
+				err = z.Close()
+			}
+		}
+		if err != nil {
+			fatal(err.Error())
+		}
+		if float64(buf.Len()) < skipRatio*float64(len(sample)) {
+			trials = append(trials, trial{name, buf.Len(), time.Since(start)})
+		}
+	}
+	if len(trials) == 0 {
+		return "s2"
+	}
+	sort.Slice(trials, func(i, j int) bool { return trials[i].time < trials[j].time })
+	switch *optimize {
+	case "speed":
+		return trials[0].name
+	case "balanced":
+		trials = trials[:(len(trials)+1)/2]
+	}
+	best := trials[0]
+	for _, t := range trials[1:] {
+		if t.size < best.size {
+			best = t
+		}
+	}
+	return best.name
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// backupPath returns where -backup-dir moves p: its path relative to the
+// working directory, or its absolute path when it is outside of it, under
+// the backup directory.
+func backupPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = p
+	}
+	rel := abs
+	if wd, err := os.Getwd(); err == nil {
+		if r, err := filepath.Rel(wd, abs); err == nil && isOutside(r) == false {
+			rel = r
+		}
+	}
+	rel = strings.TrimPrefix(rel, filepath.VolumeName(rel))
+	return filepath.Join(*backupDir, rel)
+}
+
+// isOutside reports whether the relative path rel leaves its base.
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkBackupDir fails when p can't be moved into the backup directory,
+// because the directory is a file or holds p already.
+func checkBackupDir(p string) error {
+	if fi, err := os.Stat(*backupDir); err == nil && fi.IsDir() == false {
+		return fmt.Errorf("backup-dir %s is not a directory", *backupDir)
+	}
+	dir, err := filepath.Abs(*backupDir)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	if r, err := filepath.Rel(dir, abs); err == nil && isOutside(r) == false {
+		return fmt.Errorf("%s is inside backup-dir %s", p, *backupDir)
+	}
+	return nil
+}
+
+// backupInput moves p, or every volume of the set p is the first volume
+// of when it was decoded, into the backup directory.
+func backupInput(p string) error {
+	list := []string{p}
+	if joinsVolumes(p) == true {
+		list = volumes(volumeBase(p))
+	}
+	for _, v := range list {
+		if err := backupFile(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupFile moves p to its backup path, copying it when the backup
+// directory is on another device. With -sync, the backup is on disk
+// before p is gone.
+func backupFile(p string) error {
+	dst := backupPath(p)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(p, dst)
+	if err == nil && *syncOut == true {
+		return syncDir(filepath.Dir(dst))
+	}
+	if errors.Is(err, syscall.EXDEV) == false {
+		return err
+	}
+	if err := copyFile(p, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if *syncOut == true {
+		if err := syncDir(filepath.Dir(dst)); err != nil {
+			return err
+		}
+	}
+	return os.Remove(p)
+}
+
+// copyFile copies src to dst with its permissions and modification time.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := copyBuffer(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if *syncOut == true {
+		if err := out.Sync(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// capability describes what a codec supports, printed by -capabilities.
+// MinLevel and MaxLevel are nil for codecs without levels.
+type capability struct {
+	Algorithm   string   `json:"algorithm"`
+	MinLevel    *int     `json:"min_level"`
+	MaxLevel    *int     `json:"max_level"`
+	Suffix      string   `json:"suffix"`
+	Extensions  []string `json:"extensions"`
+	Parallel    bool     `json:"parallel"`
+	Dictionary  bool     `json:"dictionary"`
+	Multistream bool     `json:"multistream"`
+}
+
+// printCapabilities prints the registered codecs with the -levels range,
+// the suffix written, the extensions recognized on -d, including those
+// of -map-ext, and whether -cores, -dict and -append apply to them.
+func printCapabilities() {
+	var caps []capability
+	for _, name := range codecNames() {
+		c := codecs[name]
+		cp := capability{Algorithm: name, Suffix: c.Suffix, Extensions: append([]string{c.Suffix}, c.Extensions...), Parallel: c.parallel, Dictionary: c.dict, Multistream: c.concat}
+		if c.MaxLevel != 0 {
+			min, max := c.MinLevel, c.MaxLevel
+			cp.MinLevel, cp.MaxLevel = &min, &max
+		}
+		seen := map[string]bool{}
+		for _, ext := range cp.Extensions {
+			seen[ext] = true
+		}
+		for ext, algo := range extMap {
+			if algo == name && seen[ext] == false {
+				cp.Extensions = append(cp.Extensions, ext)
+			}
+		}
+		sort.Strings(cp.Extensions[1:])
+		caps = append(caps, cp)
+	}
+	if *capsFmt == "json" {
+		json.NewEncoder(os.Stdout).Encode(caps)
+		return
+	}
+	yes := map[bool]string{true: "yes", false: "no"}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "algorithm\tlevels\tsuffix\textensions\tparallel\tdictionary\tmultistream")
+	for _, cp := range caps {
+		levels := "-"
+		if cp.MinLevel != nil {
+			levels = fmt.Sprintf("%d-%d", *cp.MinLevel, *cp.MaxLevel)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cp.Algorithm, levels, cp.Suffix, strings.Join(cp.Extensions, ","), yes[cp.Parallel], yes[cp.Dictionary], yes[cp.Multistream])
+	}
+	tw.Flush()
+}
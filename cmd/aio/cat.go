@@ -0,0 +1,121 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// catFiles decompresses files in order to standard output, detecting
+// the algorithm of each one unless -a was given. It reports whether any
+// file failed.
+func catFiles(files []string) (failed bool) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, file := range files {
+		currentInput = file
+		r, err := catFile(file)
+		printResult(r)
+		if err == nil {
+			continue
+		}
+		warnf("%s: %s", file, err)
+		if *keepGoing == false {
+			return true
+		}
+		failed = true
+	}
+	return failed
+}
+
+func catFile(file string) (fileResult, error) {
+	r := fileResult{Name: file, Status: "failed", Algorithm: "-", InSize: inputSize(file)}
+	z, err := openDecompressed(file)
+	if err != nil {
+		return r, err
+	}
+	defer z.Close()
+	_, err = copyBuffer(os.Stdout, z)
+	r.Algorithm, r.OutSize = z.algo, z.n
+	if err == nil {
+		r.Status = "ok"
+	}
+	return r, err
+}
+
+// openDecompressed opens file, or standard input for "-", and returns a
+// reader of its decompressed contents. The algorithm is detected from the
+// stream header unless -a was given or the suffix of file selects it.
+func openDecompressed(file string) (*decompressedFile, error) {
+	var in io.ReadCloser = os.Stdin
+	if file != "-" {
+		f, err := openInput(file)
+		if err != nil {
+			return nil, err
+		}
+		in = f
+	}
+	if limiter != nil {
+		in = &limitedFile{Reader: &limitedReader{r: in, l: limiter}, Closer: in}
+	}
+	if memLimitSz > 0 {
+		r, err := checkMemLimit(in, memLimitSz)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in = &limitedFile{Reader: r, Closer: in}
+	}
+
+	r, h := peekHeader(in)
+	var algo string
+	if setByUser("a") == true {
+		algo = *algorithm
+	} else if file != "-" {
+		algo = suffixDecoder(file, aio.Identify(h))
+	}
+	if algo != "" {
+		z, err := newDecompressor(r, algo)
+		if err != nil {
+			in.Close()
+			return nil, decodeError(err, algo, algorithmSource(file), h)
+		}
+		c, _ := z.(io.Closer)
+		return &decompressedFile{Reader: &decodeReader{r: z, algo: algo, from: algorithmSource(file), h: h}, z: c, file: in, algo: algo}, nil
+	}
+	algo, z, err := detectAlgorithm(r)
+	if err != nil {
+		in.Close()
+		return nil, decodeError(err, algo, "", nil)
+	}
+	return &decompressedFile{Reader: &decodeReader{r: z, algo: algo}, z: z, file: in, algo: algo}, nil
+}
+
+// decompressedFile closes the decoder, if it has a Close method, and the
+// file it reads from. It counts the decompressed bytes read, n, for
+// -summary.
+type decompressedFile struct {
+	io.Reader
+	z    io.Closer
+	file io.Closer
+	algo string
+	n    int64
+}
+
+func (d *decompressedFile) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	d.n += int64(n)
+	return n, err
+}
+
+func (d *decompressedFile) Close() error {
+	if d.z != nil {
+		d.z.Close()
+	}
+	return d.file.Close()
+}
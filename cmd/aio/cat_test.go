@@ -0,0 +1,90 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// openFiles returns the number of open file descriptors of the process,
+// or -1 where /proc isn't available.
+func openFiles() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// TestCatClosesReaders decompresses thousands of tiny files of every
+// algorithm and checks that neither file descriptors nor decoder
+// goroutines pile up.
+func TestCatClosesReaders(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	n := 2000
+	if testing.Short() {
+		n = 200
+	}
+	for i := 0; i < n; i++ {
+		algo := codecNames()[i%len(codecs)]
+		data, err := aio.Compress(algo, aio.DefaultLevel, []byte(fmt.Sprintf("file %d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(dir, fmt.Sprintf("f%d.%s", i, codecs[algo].Suffix))
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, p)
+	}
+
+	out, err := ioutil.TempFile(dir, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	// Finalizers would close leaked files behind the test's back.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	fds, goroutines := openFiles(), runtime.NumGoroutine()
+	if catFiles(files) == true {
+		t.Fatal("catFiles failed")
+	}
+	os.Stdout = stdout
+	if n := openFiles(); n > fds {
+		t.Errorf("%d file descriptors open after -cat, %d before", n, fds)
+	}
+	// Decoder goroutines may take a moment to return after Close.
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines+2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+2 {
+		t.Errorf("%d goroutines running after -cat, %d before", n, goroutines)
+	}
+
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(files) {
+		t.Errorf("-cat wrote %d lines, want %d", lines, len(files))
+	}
+	if strings.HasPrefix(string(data), "file 0\nfile 1\n") == false {
+		t.Errorf("-cat output starts with %q, want the files in order", data[:20])
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"flag"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pedroalbanese/aio"
+	"github.com/pedroalbanese/brotli"
+	"github.com/pedroalbanese/lzma"
+	"github.com/pedroalbanese/xz"
+)
+
+// codec is a compression algorithm as the command uses it: the aio
+// package's codec of the same name, which supplies the name, suffixes,
+// level bounds and, unless the command sets its own, the reader, with
+// what the command adds to it. An algorithm is registered there first,
+// then given its writer options in init.
+type codec struct {
+	aio.Codec
+	// concat is set when concatenated streams decode as one stream,
+	// which -append relies on.
+	concat bool
+	// parallel is set when -cores compresses on several threads, and
+	// dict when -dict is supported.
+	parallel, dict bool
+	// newWriter returns a writer compressing into w, configured from
+	// the command-line flags. size is the size of the input, which zstd
+	// stores in the frame header, or 0 when it isn't known.
+	newWriter func(w io.Writer, size int64) (io.WriteCloser, error)
+	// newReader returns a reader decompressing r, or nil for the aio
+	// package's reader. Callers must close the reader when it implements
+	// io.Closer.
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+var codecs = map[string]codec{}
+
+// contentEncodings maps the HTTP Content-Encoding tokens aio can write to
+// their algorithm. The deflate coding is the zlib format.
+var contentEncodings = map[string]string{
+	"br":      "brotli",
+	"deflate": "zlib",
+	"gzip":    "gzip",
+	"x-gzip":  "gzip",
+	"zstd":    "zstd",
+}
+
+// register adds the codec of the aio package named name with what c
+// adds to it. Without a newWriter of its own, the codec compresses at the
+// level of -levels or -level-name, within the bounds of the package's
+// codec.
+func register(name string, c codec) {
+	lib, ok := aio.Lookup(name)
+	if ok == false {
+		panic("aio: no codec for " + name + " in the aio package")
+	}
+	c.Codec = lib
+	if c.newWriter == nil {
+		c.newWriter = func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return lib.NewWriter(w, level(name, lib.MinLevel, aio.DefaultLevel, lib.MaxLevel))
+		}
+	}
+	if c.newReader == nil {
+		c.newReader = func(r io.Reader) (io.Reader, error) {
+			return lib.NewReader(r)
+		}
+	}
+	codecs[name] = c
+}
+
+// codecNames returns the names of the registered codecs in order.
+func codecNames() []string {
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	register("brotli", codec{
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return brotli.NewWriterOptions(w, brotli.WriterOptions{
+				Quality: level("brotli", brotli.BestSpeed, brotli.DefaultCompression, brotli.BestCompression),
+				LGWin:   *brotliWin,
+			}), nil
+		},
+	})
+	register("bzip2", codec{
+		concat: true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			// The library's compression level is the block size.
+			blocks := level("bzip2", bzip2.BestSpeed, *blockSize, bzip2.BestCompression)
+			if setByUser("block-size") == true {
+				blocks = *blockSize
+			}
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: blocks})
+		},
+	})
+	register("gzip", codec{
+		concat: true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			gz, err := gzip.NewWriterLevel(w, deflateLevel("gzip"))
+			if err != nil {
+				return nil, err
+			}
+			gz.Comment = *gzComment
+			gz.OS = byte(*gzOS)
+			if *compat == true {
+				gzipCompat(&gz.Header)
+			}
+			if *gzMtime != 0 {
+				gz.ModTime = time.Unix(*gzMtime, 0)
+			}
+			if *rsyncable == true {
+				return newRsyncWriter(gz), nil
+			}
+			return gz, nil
+		},
+		newReader: func(r io.Reader) (io.Reader, error) {
+			if *ignoreJunk == true {
+				return newGzipTrailingReader(r)
+			}
+			return aio.DecompressReader("gzip", r)
+		},
+	})
+	register("lzma", codec{
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return lzma.NewWriterLevel(w, level("lzma", lzma.BestSpeed, lzma.DefaultCompression, lzma.BestCompression)), nil
+		},
+	})
+	register("s2", codec{
+		concat:   true,
+		parallel: true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return &emptyStreamWriter{WriteCloser: newS2Writer(w), w: w, empty: s2Empty}, nil
+		},
+	})
+	register("snappy", codec{
+		concat:   true,
+		parallel: true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return &emptyStreamWriter{WriteCloser: newS2Writer(w, s2.WriterSnappyCompat()), w: w, empty: snappyEmpty}, nil
+		},
+	})
+	register("xz", codec{
+		concat:   true,
+		parallel: true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			config := xz.WriterConfig{
+				DictCap:    xzDictCaps[level("xz", 1, 6, 9)],
+				CheckSum:   xzCheck,
+				NoCheckSum: xzCheck == xz.None,
+			}
+			if *cores > 1 {
+				return newXzParallelWriter(w, config, *cores), nil
+			}
+			return config.NewWriter(w)
+		},
+	})
+	register("zlib", codec{
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, deflateLevel("zlib"))
+		},
+	})
+	register("zstd", codec{
+		concat:   true,
+		parallel: true,
+		dict:     true,
+		newWriter: func(w io.Writer, size int64) (io.WriteCloser, error) {
+			if *seekable == true {
+				return newSeekableWriter(w)
+			}
+			if inputMeta != nil {
+				if err := writeMeta(w, inputMeta); err != nil {
+					return nil, err
+				}
+			}
+			z, err := zstd.NewWriter(w, zstdEncoderOptions...)
+			if err != nil {
+				return nil, err
+			}
+			// The frame fails to close if the file changes size meanwhile.
+			z.ResetContentSize(w, size)
+			return z, nil
+		},
+		newReader: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r, zstdDecoderOptions...)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	})
+
+	// Algorithms registered with the aio package alone are used as they
+	// are.
+	for _, name := range aio.Algorithms() {
+		if _, ok := codecs[name]; ok == false {
+			register(name, codec{})
+		}
+	}
+
+	// The suffix of the output follows the algorithm auto picks.
+	flag.Lookup("a").Usage = "compression algorithm: " + strings.Join(codecNames(), ", ") + ", or auto: gzip for tiny inputs, s2 for incompressible ones, else zstd, with its suffix"
+}
+
+func newS2Writer(w io.Writer, opts ...s2.WriterOption) io.WriteCloser {
+	if *levelName == "best" {
+		opts = append(opts, s2.WriterBestCompression())
+	}
+	if *store == true {
+		opts = append(opts, s2.WriterUncompressed())
+	}
+	return s2.NewWriter(w, opts...)
+}
+
+// s2Empty and snappyEmpty are the stream identifiers s2 and snappy
+// streams start with, and all there is of the stream of an empty input.
+var (
+	s2Empty     = []byte("\xff\x06\x00\x00S2sTwO")
+	snappyEmpty = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// emptyStreamWriter writes empty to w on Close when no data was written.
+// The s2 encoder writes nothing at all for an empty input, not even the
+// stream identifier.
+type emptyStreamWriter struct {
+	io.WriteCloser
+	w       io.Writer
+	empty   []byte
+	written bool
+}
+
+func (e *emptyStreamWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		e.written = true
+	}
+	return e.WriteCloser.Write(p)
+}
+
+func (e *emptyStreamWriter) Close() error {
+	if err := e.WriteCloser.Close(); err != nil || e.written == true {
+		return err
+	}
+	_, err := e.w.Write(e.empty)
+	return err
+}
+
+// xzDictCaps are the dictionary sizes of the xz presets 0 to 9, which
+// -level selects among like xz -0 to -9.
+var xzDictCaps = [...]int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// newCompressor returns a writer compressing into w with algo, for an
+// input of size bytes, 0 when unknown.
+func newCompressor(w io.Writer, algo string, size int64) (io.WriteCloser, error) {
+	return codecs[algo].newWriter(w, size)
+}
+
+// newDecompressor returns a reader decompressing r with algo. Callers
+// must close the reader when it implements io.Closer.
+func newDecompressor(r io.Reader, algo string) (io.Reader, error) {
+	return codecs[algo].newReader(r)
+}
+
+// level returns the -levels entry of algo, or else fast, def or best
+// according to -level-name.
+func level(algo string, fast, def, best int) int {
+	if n, ok := levelMap[algo]; ok {
+		return n
+	}
+	switch *levelName {
+	case "fast":
+		return fast
+	case "best":
+		return best
+	}
+	return def
+}
+
+// deflateLevel returns the gzip or zlib level. Deflate has stored
+// blocks, so -store writes the data as is.
+func deflateLevel(algo string) int {
+	if *store == true {
+		return gzip.NoCompression
+	}
+	return level(algo, gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression)
+}
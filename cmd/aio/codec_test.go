@@ -0,0 +1,296 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// TestXzLevels compresses 1.5 MiB of noise repeated twice, which the
+// 1 MiB dictionary of the fast level can't match and the 64 MiB one of
+// the best level can.
+func TestXzLevels(t *testing.T) {
+	dir := t.TempDir()
+	noise := make([]byte, 3<<19)
+	rand.New(rand.NewSource(1)).Read(noise)
+	in := append(append([]byte(nil), noise...), noise...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	size := func(args ...string) int64 {
+		t.Helper()
+		mustRun(t, dir, append([]string{"-k", "-f", "-a", "xz", "-o", "out"}, append(args, "f")...)...)
+		r := mustRun(t, dir, "-c", "-d", "-a", "xz", "out")
+		if r.stdout != string(in) {
+			t.Fatalf("aio -a xz %v doesn't round trip", args)
+		}
+		fi, err := os.Stat(filepath.Join(dir, "out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	for _, cores := range []string{"1", "2"} {
+		fast, best := size("-cores", cores, "-level-name", "fast"), size("-cores", cores, "-level-name", "best")
+		if best > int64(len(noise))*11/10 || fast < int64(len(in))*9/10 {
+			t.Errorf("-cores %s: fast output is %d bytes and best %d, want best to match the repeat and fast not to", cores, fast, best)
+		}
+		if n := size("-cores", cores, "-levels", "xz=0"); n < int64(len(in))*9/10 {
+			t.Errorf("-cores %s -levels xz=0: %d bytes, want the repeat unmatched", cores, n)
+		}
+	}
+	if r := runAio(t, nil, dir, "", "-k", "-c", "-levels", "xz=10", "-a", "xz", "f"); r.status == 0 {
+		t.Errorf("aio -levels xz=10 succeeded, want it refused")
+	}
+}
+
+// TestEmptyFile compresses an empty file with every algorithm and
+// decompresses it back, naming the algorithm and detecting it.
+func TestEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, algo := range codecNames() {
+		f := filepath.Join(dir, "f")
+		if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mustRun(t, dir, "-f", "-a", algo, "-o", "f.z", "f")
+		fi, err := os.Stat(filepath.Join(dir, "f.z"))
+		if err != nil || fi.Size() == 0 {
+			t.Fatalf("%s: the output of an empty file is empty or missing: %v", algo, err)
+		}
+		mustRun(t, dir, "-d", "-k", "-a", algo, "-o", "f", "f.z")
+		if data, err := ioutil.ReadFile(f); err != nil || len(data) != 0 {
+			t.Errorf("%s: f = %q, %v, want it empty", algo, data, err)
+		}
+		if algo == "brotli" {
+			continue
+		}
+		if r := mustRun(t, dir, "-d", "-c", "f.z"); r.stdout != "" {
+			t.Errorf("%s: detected, the empty stream decodes to %q", algo, r.stdout)
+		}
+	}
+}
+
+func TestBzip2BlockSize(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(1 << 20)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{}
+	for _, n := range []string{"1", "9"} {
+		r := mustRun(t, dir, "-c", "-a", "bzip2", "-block-size", n, "f")
+		if strings.HasPrefix(r.stdout, "BZh"+n) == false {
+			t.Errorf("-block-size %s: the header is %q, want BZh%s", n, r.stdout[:minInt(4, len(r.stdout))], n)
+		}
+		outputs[n] = r.stdout
+	}
+	if outputs["1"] == outputs["9"] {
+		t.Errorf("-block-size 1 and 9 wrote the same output")
+	}
+	for _, args := range [][]string{{"-a", "gzip", "-block-size", "9"}, {"-a", "bzip2", "-block-size", "0"}, {"-a", "bzip2", "-block-size", "10"}} {
+		if r := runAio(t, nil, dir, "", append(append([]string{"-c"}, args...), "f")...); r.status == 0 {
+			t.Errorf("aio %v succeeded, want it refused", args)
+		}
+	}
+}
+
+func TestSnappySuffix(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(64 << 10)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, dir, "-a", "snappy", "f")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "f.sz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aio.Identify(data) != "snappy" {
+		t.Errorf("f.sz is identified as %q, want snappy", aio.Identify(data))
+	}
+	mustRun(t, dir, "-d", "f.sz")
+	if out, err := ioutil.ReadFile(filepath.Join(dir, "f")); err != nil || bytes.Equal(out, in) == false {
+		t.Errorf("aio -d f.sz wrote %d bytes, %v, want %d", len(out), err, len(in))
+	}
+}
+
+// TestDict compresses with the dictionary of testdata/zstd.dict, trained
+// by zstd --train on small JSON records, and decompresses with it with
+// and without -a zstd.
+func TestDict(t *testing.T) {
+	dict, err := filepath.Abs(filepath.Join("testdata", "zstd.dict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in := `{"id": 7, "name": "user7", "email": "user7@example.com", "active": true, "roles": ["reader", "writer"]}` + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, dir, "-k", "-a", "zstd", "-dict", dict, "f")
+	for _, args := range [][]string{
+		{"-d", "-c", "-a", "zstd", "-dict", dict, "f.zst"},
+		{"-d", "-c", "-dict", dict, "f.zst"},
+		{"-cat", "-dict", dict, "f.zst"},
+	} {
+		if r := mustRun(t, dir, args...); r.stdout != in {
+			t.Errorf("aio %s = %q, want %q", strings.Join(args, " "), r.stdout, in)
+		}
+	}
+	if r := runAio(t, nil, dir, "", "-d", "-c", "f.zst"); r.status == 0 {
+		t.Errorf("aio -d -c f.zst without the dictionary succeeded")
+	}
+	if r := runAio(t, nil, dir, "", "-k", "-f", "-a", "gzip", "-dict", dict, "f"); r.status == 0 {
+		t.Errorf("aio -a gzip -dict succeeded, want it refused")
+	}
+}
+
+// TestLongWindow decompresses a stream of a 512 MiB window with a smaller
+// -long, which must not lower the window the decoder accepts.
+func TestLongWindow(t *testing.T) {
+	dir := t.TempDir()
+	in := string(sampleText(64 << 10))
+	// Read from standard input, the size is unknown and the frame header
+	// holds the whole window.
+	r := runAio(t, nil, dir, in, "-c", "-a", "zstd", "-long=29")
+	if r.status != 0 {
+		t.Fatalf("aio -c -a zstd -long=29: exit status %d: %s", r.status, r.stderr)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.zst"), []byte(r.stdout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-d", "-c", "-a", "zstd", "-long=27", "f.zst"},
+		{"-d", "-c", "-long=27", "f.zst"},
+	} {
+		if r := mustRun(t, dir, args...); r.stdout != in {
+			t.Errorf("aio %s wrote %d bytes, want %d", strings.Join(args, " "), len(r.stdout), len(in))
+		}
+	}
+}
+
+// TestLevelNames checks that fast and best select the extremes of each
+// codec, from the output header where it records the level and against
+// the library's output at that level elsewhere.
+func TestLevelNames(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(256 << 10)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	libOutput := func(algo string, level int) string {
+		data, err := aio.Compress(algo, level, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	tests := []struct {
+		algo, name string
+		check      func(out string) bool
+	}{
+		// XFL is 2 at the best level and 4 at the fastest.
+		{"gzip", "best", func(out string) bool { return out[8] == 2 }},
+		{"gzip", "fast", func(out string) bool { return out[8] == 4 }},
+		// FLEVEL, the top bits of FLG, is 3 at levels 7-9, 0 at 0-1.
+		{"zlib", "best", func(out string) bool { return out[1]>>6 == 3 }},
+		{"zlib", "fast", func(out string) bool { return out[1]>>6 == 0 }},
+		{"bzip2", "best", func(out string) bool { return out[:4] == "BZh9" }},
+		{"bzip2", "fast", func(out string) bool { return out[:4] == "BZh1" }},
+		// The LZMA2 properties byte of the first block encodes the
+		// dictionary, 64 MiB at preset 9 and 1 MiB at preset 1.
+		{"xz", "best", func(out string) bool { return out[14:17] == "\x21\x01\x1c" }},
+		{"xz", "fast", func(out string) bool { return out[14:17] == "\x21\x01\x10" }},
+		{"brotli", "best", func(out string) bool { return out == libOutput("brotli", 11) }},
+		{"brotli", "fast", func(out string) bool { return out == libOutput("brotli", 0) }},
+		{"lzma", "best", func(out string) bool { return out == libOutput("lzma", 9) }},
+		{"lzma", "fast", func(out string) bool { return out == libOutput("lzma", 1) }},
+	}
+	for _, tt := range tests {
+		r := mustRun(t, dir, "-c", "-a", tt.algo, "-level-name", tt.name, "f")
+		if len(r.stdout) < 20 || tt.check(r.stdout) == false {
+			t.Errorf("-a %s -level-name %s: the output doesn't have the %s level, header %x", tt.algo, tt.name, tt.name, r.stdout[:minInt(20, len(r.stdout))])
+		}
+	}
+
+	// zstd records no level; best is its slowest, densest level.
+	sizes := map[string]int{}
+	for _, name := range []string{"fast", "default", "best"} {
+		sizes[name] = len(mustRun(t, dir, "-c", "-a", "zstd", "-level-name", name, "f").stdout)
+	}
+	if (sizes["best"] < sizes["default"] && sizes["default"] < sizes["fast"]) == false {
+		t.Errorf("zstd output sizes are %v, want best < default < fast", sizes)
+	}
+	// An explicit -levels entry wins over -level-name.
+	if out := mustRun(t, dir, "-c", "-a", "gzip", "-level-name", "best", "-levels", "gzip=1", "f").stdout; out[8] != 4 {
+		t.Errorf("-level-name best -levels gzip=1: XFL %d, want 4", out[8])
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// TestMultistream decodes files of two concatenated bzip2 and xz members,
+// the second appended with -append.
+func TestMultistream(t *testing.T) {
+	dir := t.TempDir()
+	first, second := sampleText(300<<10), []byte("the second member\n")
+	for _, algo := range []string{"bzip2", "xz"} {
+		t.Run(algo, func(t *testing.T) {
+			out := "f." + codecs[algo].Suffix
+			if err := ioutil.WriteFile(filepath.Join(dir, "a"), first, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "b"), second, 0644); err != nil {
+				t.Fatal(err)
+			}
+			mustRun(t, dir, "-k", "-f", "-a", algo, "-o", out, "a")
+			mustRun(t, dir, "-k", "-append", "-a", algo, "-o", out, "b")
+			data, err := ioutil.ReadFile(filepath.Join(dir, out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			magic := map[string]string{"bzip2": "BZh", "xz": "\xfd7zXZ\x00"}[algo]
+			if n := bytes.Count(data, []byte(magic)); n != 2 {
+				t.Fatalf("%s holds %d stream headers, want 2", out, n)
+			}
+			r := mustRun(t, dir, "-c", "-d", out)
+			if r.stdout != string(first)+string(second) {
+				t.Errorf("aio -d of two %s members wrote %d bytes, want %d", algo, len(r.stdout), len(first)+len(second))
+			}
+
+			// Members compressed separately and joined, as cat a.bz2
+			// b.bz2 does.
+			var joined []byte
+			for _, in := range [][]byte{first, second} {
+				z, err := aio.Compress(algo, aio.DefaultLevel, in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				joined = append(joined, z...)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "joined"), joined, 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, args := range [][]string{{"-c", "-d", "joined"}, {"-cat", "joined"}} {
+				if r := mustRun(t, dir, args...); r.stdout != string(first)+string(second) {
+					t.Errorf("aio %v of two joined %s members wrote %d bytes, want %d", args, algo, len(r.stdout), len(first)+len(second))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compareFile decompresses file and compares it with the reference file
+// ref, reading both in lockstep. It prints "identical" or the offset of
+// the first difference and returns 0, 1 or 2 when a file couldn't be
+// read, like cmp.
+func compareFile(ref, file string) int {
+	a, err := os.Open(ref)
+	if err != nil {
+		warnf("%s", err)
+		return 2
+	}
+	defer a.Close()
+	b, err := openDecompressed(file)
+	if err != nil {
+		warnf("%s: %s", file, err)
+		return 2
+	}
+	defer b.Close()
+
+	off, err := firstDifference(a, b)
+	if err != nil {
+		warnf("%s: %s", file, err)
+		return 2
+	}
+	if off < 0 {
+		fmt.Println("identical")
+		return 0
+	}
+	fmt.Printf("%s %s differ: byte %d\n", ref, file, off+1)
+	return 1
+}
+
+// firstDifference returns the offset of the first byte where a and b
+// differ, or where the shorter one ends, and -1 when they are equal.
+func firstDifference(a, b io.Reader) (int64, error) {
+	bufA := make([]byte, bufferSize)
+	bufB := make([]byte, bufferSize)
+	var off int64
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, errB
+		}
+		n := na
+		if nb < n {
+			n = nb
+		}
+		if i := mismatch(bufA[:n], bufB[:n]); i >= 0 {
+			return off + int64(i), nil
+		}
+		if na != nb {
+			return off + int64(n), nil
+		}
+		if na < len(bufA) {
+			return -1, nil
+		}
+		off += int64(n)
+	}
+}
+
+func mismatch(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"compress/gzip"
+	"time"
+)
+
+// gzipOSUnix is the OS byte GNU gzip writes on Unix systems.
+const gzipOSUnix = 3
+
+// gzipCompat fills h as GNU gzip does by default: the name and
+// modification time of the input, none for standard input, and OS byte 3.
+// -os and -mtime still take precedence. The XFL byte and the default level
+// 6 already match. Names are converted to Latin-1 where GNU gzip copies
+// their bytes, so only ASCII names compare equal, and Go's deflate encoder
+// picks other matches and block boundaries than GNU gzip, so the headers
+// match byte for byte but the deflate data doesn't.
+func gzipCompat(h *gzip.Header) {
+	if setByUser("os") == false {
+		h.OS = gzipOSUnix
+	}
+	if inputMeta == nil {
+		return
+	}
+	if inputMeta.MTime > 0 {
+		h.ModTime = time.Unix(inputMeta.MTime, 0)
+	}
+	if latin1(inputMeta.Name) == true {
+		h.Name = inputMeta.Name
+	} else {
+		warnf("warning: %s isn't Latin-1, leaving it out of the gzip header", inputMeta.Name)
+	}
+}
+
+// latin1 reports whether s can be stored in a gzip header, which holds
+// NUL terminated Latin-1 strings.
+func latin1(s string) bool {
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompatHeader compresses the contents of testdata/compat.txt.gz,
+// written by GNU gzip 1.12 at its default level, under the name and
+// modification time its header stores, and compares the headers byte for
+// byte: MTIME, XFL, OS and the name.
+func TestCompatHeader(t *testing.T) {
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", "compat.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(bytes.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	p := filepath.Join(dir, z.Name)
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, z.ModTime, z.ModTime); err != nil {
+		t.Fatal(err)
+	}
+	r := mustRun(t, dir, "-c", "-compat", z.Name)
+	n := 10 + len(z.Name) + 1
+	if len(r.stdout) < n || r.stdout[:n] != string(fixture[:n]) {
+		t.Errorf("aio -compat header = %x, GNU gzip's = %x", r.stdout[:minInt(n, len(r.stdout))], fixture[:n])
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// concatFiles compresses the contents of files, in order, as a single
+// stream on standard output, so the stream decompresses to their byte
+// concatenation. With -recompress, each file is decompressed first. A
+// file that can't be read is reported and skipped. It reports whether
+// any file failed.
+//
+// With -summary, the output of a file is what the compressor wrote while
+// reading it, and the end of the stream counts for the last file, so
+// only the totals are exact.
+func concatFiles(files []string) (failed bool) {
+	var dst io.Writer = os.Stdout
+	if limiter != nil {
+		dst = &limitedWriter{w: dst, l: limiter}
+	}
+	out := &countWriter{w: dst}
+	z, err := newCompressor(out, *algorithm, 0)
+	if err != nil {
+		fatal(err.Error())
+	}
+	var last fileResult
+	var mark int64
+	for i, file := range files {
+		currentInput = file
+		n, err := concatFile(z, file)
+		r := fileResult{Name: file, Status: "ok", Algorithm: *algorithm, Level: resultLevel(*algorithm), InSize: n}
+		if err != nil {
+			warnf("%s: %s", file, err)
+			failed = true
+			r.Status = "failed"
+		}
+		if i == len(files)-1 {
+			last = r
+			break
+		}
+		r.OutSize, mark = out.n-mark, out.n
+		printResult(r)
+	}
+	if err := z.Close(); err != nil {
+		fatal(err.Error())
+	}
+	last.OutSize = out.n - mark
+	printResult(last)
+	return failed
+}
+
+func concatFile(z io.Writer, file string) (int64, error) {
+	var in io.ReadCloser = os.Stdin
+	if file != "-" {
+		f, err := openInput(file)
+		if err != nil {
+			return 0, err
+		}
+		in = f
+	}
+	defer in.Close()
+	var src io.Reader = in
+	if *recompress != "" {
+		var err error
+		if memLimitSz > 0 {
+			if src, err = checkMemLimit(src, memLimitSz); err != nil {
+				return 0, err
+			}
+		}
+		_, zr, err := detectAlgorithm(src)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		src = zr
+	}
+	return copyBuffer(z, src)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pedroalbanese/aio"
+)
+
+var errTruncated = errors.New("truncated stream")
+
+// peekHeader returns a reader equivalent to r together with the first
+// bytes of the stream, for decodeError.
+func peekHeader(r io.Reader) (io.Reader, []byte) {
+	br := bufio.NewReader(r)
+	h, _ := br.Peek(aio.HeaderSize)
+	return br, h
+}
+
+// decodeError replaces an error decoding a stream with algo by one
+// telling whether the stream is of another format, going by its header h,
+// truncated or corrupt. Other errors are returned as they are. from tells
+// how algo was chosen, as algorithmSource words it, when it wasn't
+// detected from h.
+func decodeError(err error, algo, from string, h []byte) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if found := aio.Identify(h); found != "" && found != algo && !(isS2(found) && isS2(algo)) {
+		return fmt.Errorf("algorithm mismatch: file looks like %s but %s", found, from)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return errTruncated
+	}
+	if isChecksumError(err) {
+		return fmt.Errorf("integrity check failed: %s", err)
+	}
+	return err
+}
+
+// algorithmSource tells how the algorithm decoding file was chosen when
+// it wasn't detected: with -a, with -map-ext or by the suffix of file.
+func algorithmSource(file string) string {
+	if setByUser("a") == true {
+		return fmt.Sprintf("-a %s was used", *algorithm)
+	}
+	ext := strings.TrimPrefix(path.Ext(volumeBase(file)), ".")
+	if algo, ok := extMap[ext]; ok == true {
+		return fmt.Sprintf("-map-ext maps .%s to %s", ext, algo)
+	}
+	return fmt.Sprintf("its .%s suffix is that of %s", ext, suffixAlgorithm(ext))
+}
+
+// isS2 reports whether algo is read by the s2 decoder, which reads both
+// s2 and snappy streams.
+func isS2(algo string) bool {
+	return algo == "s2" || algo == "snappy"
+}
+
+// checksumErrors are the messages of the checksum errors of the bzip2 and
+// xz packages, which don't export them. TestChecksumErrors pins them.
+var checksumErrors = map[string]bool{
+	"bzip2: corrupted input: mismatching block checksum":  true,
+	"bzip2: corrupted input: mismatching stream checksum": true,
+	"xz: checksum error for block":                        true,
+}
+
+func isChecksumError(err error) bool {
+	for _, target := range []error{gzip.ErrChecksum, zlib.ErrChecksum, zstd.ErrCRCMismatch, s2.ErrCRC} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return checksumErrors[err.Error()]
+}
+
+// decodeReader maps the errors of a decoder with decodeError.
+type decodeReader struct {
+	r    io.Reader
+	algo string
+	from string
+	h    []byte
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	return n, decodeError(err, d.algo, d.from, d.h)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// TestChecksumErrors changes a stored checksum of a stream of each
+// algorithm with one and expects isChecksumError to recognize the error,
+// which for bzip2 and xz pins the messages in checksumErrors.
+func TestChecksumErrors(t *testing.T) {
+	tests := []struct {
+		algo string
+		// offset returns the offset of a checksum byte in data.
+		offset func(data []byte) int
+	}{
+		{"bzip2", func(data []byte) int { return 10 }},
+		{"gzip", func(data []byte) int { return len(data) - 8 }},
+		{"s2", func(data []byte) int { return 14 }},
+		{"xz", func(data []byte) int {
+			// The block check is right before the index, whose size the
+			// stream footer holds.
+			index := (int(binary.LittleEndian.Uint32(data[len(data)-8:])) + 1) * 4
+			return len(data) - 12 - index - 1
+		}},
+		{"zlib", func(data []byte) int { return len(data) - 1 }},
+		{"zstd", func(data []byte) int { return len(data) - 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			data, err := aio.Compress(tt.algo, aio.DefaultLevel, sampleText(4<<10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[tt.offset(data)] ^= 0xff
+			_, err = aio.Decompress(tt.algo, data)
+			if err == nil || isChecksumError(err) == false {
+				t.Errorf("decoding with a changed checksum: %v, want a checksum error", err)
+			}
+		})
+	}
+}
+
+// TestMismatchSource decodes a gzip file with xz, chosen with -a and
+// with -map-ext, and expects the error to name what chose xz.
+func TestMismatchSource(t *testing.T) {
+	dir := t.TempDir()
+	data, err := aio.Compress("gzip", aio.DefaultLevel, []byte("gzip\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.dat"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-d", "-c", "-a", "xz", "f.dat"}, "file looks like gzip but -a xz was used"},
+		{[]string{"-d", "-c", "-map-ext", ".dat=xz", "f.dat"}, "file looks like gzip but -map-ext maps .dat to xz"},
+	} {
+		r := runAio(t, nil, dir, "", tt.args...)
+		if r.status == 0 || strings.Contains(r.stderr, tt.want) == false {
+			t.Errorf("aio %s: exit status %d, stderr %q, want %q", strings.Join(tt.args, " "), r.status, r.stderr, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// entropyFiles reads each file, or standard input for "-" or no files,
+// once and prints the entropy of its bytes with the algorithm -a auto
+// would pick. The entropy ignores repeated strings, so it is an upper
+// bound of what the dictionary coders reach: text is around 4.5 bits per
+// byte, compressed or encrypted data close to 8. It reports whether any
+// file could not be read.
+func entropyFiles(files []string) (failed bool) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, file := range files {
+		var h byteHistogram
+		if err := readInto(&h, file); err != nil {
+			warnf("%s: %s", file, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: %.3f bits/byte, %d bytes, suggest %s\n", file, h.entropy(), h.total, autoAlgorithm(h.entropy(), h.total))
+	}
+	return failed
+}
+
+// readInto copies file, or standard input for "-", into w.
+func readInto(w io.Writer, file string) error {
+	var in io.ReadCloser = os.Stdin
+	if file != "-" {
+		f, err := openInput(file)
+		if err != nil {
+			return err
+		}
+		in = f
+	}
+	defer in.Close()
+	_, err := copyBuffer(w, in)
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+const envInput = "environment defaults\n"
+
+// envDir returns a directory holding f, the plain input, and f.gz.
+func envDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte(envInput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := aio.Compress("gzip", aio.DefaultLevel, []byte(envInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.gz"), gz, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestEnvAlgorithm(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		// file is the output to identify, or "" for standard output; algo
+		// is its algorithm, or the decoded input when empty.
+		file, algo string
+	}{
+		{"compress", "AIO_ALGORITHM=zstd", []string{"-k", "f"}, "f.zst", "zstd"},
+		{"flag wins", "AIO_ALGORITHM=zstd", []string{"-k", "-a", "bzip2", "f"}, "f.bz2", "bzip2"},
+		{"suffix wins", "AIO_ALGORITHM=xz", []string{"-k", "-s", "zst", "f"}, "f.zst", "zstd"},
+		{"decompress detects", "AIO_ALGORITHM=zstd", []string{"-d", "-c", "f.gz"}, "", ""},
+		{"cat detects", "AIO_ALGORITHM=zstd", []string{"-cat", "f.gz"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := envDir(t)
+			r := runAio(t, []string{tt.env}, dir, "", tt.args...)
+			if r.status != 0 {
+				t.Fatalf("%s aio %s: exit status %d: %s", tt.env, strings.Join(tt.args, " "), r.status, r.stderr)
+			}
+			if tt.file == "" {
+				if r.stdout != envInput {
+					t.Errorf("%s aio %s wrote %q, want %q", tt.env, strings.Join(tt.args, " "), r.stdout, envInput)
+				}
+				return
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := aio.Identify(data); got != tt.algo {
+				t.Errorf("%s aio %s wrote %s as %s, want %s", tt.env, strings.Join(tt.args, " "), tt.file, got, tt.algo)
+			}
+		})
+	}
+}
+
+func TestEnvInvalid(t *testing.T) {
+	tests := []struct {
+		env  string
+		args []string
+		want string
+	}{
+		{"AIO_ALGORITHM=bogus", []string{"-k", "f"}, "unknown algorithm bogus"},
+		{"AIO_LEVEL=99", []string{"-k", "-a", "gzip", "f"}, "invalid gzip level 99"},
+		{"AIO_LEVEL=fastest", []string{"-k", "-a", "gzip", "f"}, "invalid gzip level fastest"},
+		{"AIO_LEVEL=3", []string{"-k", "-a", "s2", "f"}, "s2 has no levels"},
+	}
+	for _, tt := range tests {
+		r := runAio(t, []string{tt.env}, envDir(t), "", tt.args...)
+		if r.status == 0 || strings.Contains(r.stderr, tt.want) == false {
+			t.Errorf("%s aio %s: exit status %d, want an error containing %q", tt.env, strings.Join(tt.args, " "), r.status, tt.want)
+		}
+	}
+}
+
+func TestEnvLevel(t *testing.T) {
+	// The gzip XFL byte is 2 at the best level and 4 at the fastest.
+	tests := []struct {
+		env  []string
+		args []string
+		xfl  byte
+	}{
+		{[]string{"AIO_LEVEL=best"}, nil, 2},
+		{[]string{"AIO_LEVEL=9"}, nil, 2},
+		{[]string{"AIO_LEVEL=1"}, nil, 4},
+		{[]string{"AIO_ALGORITHM=gzip", "AIO_LEVEL=fast"}, nil, 4},
+		{[]string{"AIO_LEVEL=best"}, []string{"-level-name", "fast"}, 4},
+		{[]string{"AIO_LEVEL=best"}, []string{"-levels", "gzip=1"}, 4},
+		{[]string{"AIO_LEVEL=best"}, []string{"-store"}, 0},
+	}
+	for _, tt := range tests {
+		args := append([]string{"-c"}, tt.args...)
+		r := runAio(t, tt.env, envDir(t), "", append(args, "f")...)
+		if r.status != 0 {
+			t.Fatalf("%v aio %v: exit status %d: %s", tt.env, args, r.status, r.stderr)
+		}
+		if len(r.stdout) < 10 {
+			t.Errorf("%v aio %v: output %q, too short for a gzip header", tt.env, args, r.stdout)
+		} else if r.stdout[8] != tt.xfl {
+			t.Errorf("%v aio %v: XFL byte %q, want %d", tt.env, args, r.stdout[8:9], tt.xfl)
+		}
+	}
+	// Decoding ignores AIO_LEVEL, which would otherwise be refused.
+	r := runAio(t, []string{"AIO_LEVEL=9"}, envDir(t), "", "-d", "-c", "f.gz")
+	if r.status != 0 || r.stdout != envInput {
+		t.Errorf("AIO_LEVEL=9 aio -d -c f.gz: exit status %d: %s", r.status, r.stderr)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// extractFile decompresses file, or standard input for "-", and extracts
+// the tar archive it holds into dir. Entries keep their permissions and
+// modification times. Existing files are only replaced with -f.
+func extractFile(file, dir string) error {
+	z, err := openDecompressed(file)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	// Directory modes and times are set last, as extracting into a
+	// directory changes its modification time, and its mode may not let
+	// its own entries in.
+	type dirAttrs struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirAttrs
+	tr := tar.NewReader(z)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, err := entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		mode := hdr.FileInfo().Mode().Perm()
+		// An earlier entry may have made a parent a link to anywhere,
+		// whatever the names look like.
+		if err := checkParents(dir, name, hdr.Typeflag == tar.TypeDir); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{dst, mode, hdr.ModTime})
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractReg(dst, mode, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// A link out of dir would let a later entry write through it.
+			if path.IsAbs(hdr.Linkname) || escapes(path.Join(path.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("%s: link to %s leaves the extraction directory", hdr.Name, hdr.Linkname)
+			}
+			if err := replaceable(dst); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, dst); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			target, err := entryPath(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := checkParents(dir, target, false); err != nil {
+				return err
+			}
+			if err := replaceable(dst); err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(dir, filepath.FromSlash(target)), dst); err != nil {
+				return err
+			}
+			continue
+		default:
+			warnf("%s: skipping %s, not a file, directory or link", file, hdr.Name)
+			continue
+		}
+		if err := os.Chtimes(dst, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	// Innermost first, so a read-only parent doesn't refuse the change.
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].path, string(filepath.Separator)) > strings.Count(dirs[j].path, string(filepath.Separator))
+	})
+	for _, d := range dirs {
+		if err := os.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+	// Reading the end of the archive doesn't consume the end of the
+	// compressed stream, which holds its check.
+	_, err = io.Copy(ioutil.Discard, z)
+	return err
+}
+
+// extractReg writes the contents of a regular file entry to dst.
+func extractReg(dst string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := replaceable(dst); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := copyBuffer(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceable removes dst when it exists and -f was given, so a file is
+// never written through a link that was already there.
+func replaceable(dst string) error {
+	fi, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *force == false || fi.IsDir() == true {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	return os.Remove(dst)
+}
+
+// entryPath returns the cleaned name of a tar entry, refusing absolute
+// names and names leaving the extraction directory.
+func entryPath(name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("%s: absolute entry name", name)
+	}
+	p := path.Clean(name)
+	if escapes(p) {
+		return "", fmt.Errorf("%s: entry name leaves the extraction directory", name)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// checkParents refuses name, a cleaned entry path, when one of its parent
+// directories under dir is a symbolic link, and name itself too when whole
+// is set. Writing through the link could leave dir.
+func checkParents(dir, name string, whole bool) error {
+	parts := strings.Split(name, "/")
+	if whole == false {
+		parts = parts[:len(parts)-1]
+	}
+	p := dir
+	for _, part := range parts {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: %s is a symbolic link", name, p)
+		}
+	}
+	return nil
+}
+
+// escapes reports whether the cleaned relative path p leaves its root.
+func escapes(p string) bool {
+	return p == ".." || strings.HasPrefix(p, "../")
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTarGz writes a gzip compressed tar archive of entries to a file in
+// dir and returns its path.
+func writeTarGz(t *testing.T, dir string, entries []*tar.Header, data map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(z)
+	for _, hdr := range entries {
+		body := data[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(body))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "archive.tar.gz")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1500000000, 0)
+	archive := writeTarGz(t, dir, []*tar.Header{
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime},
+		{Name: "d/f.txt", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime},
+		{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: "f.txt"},
+		{Name: "h", Typeflag: tar.TypeLink, Linkname: "d/f.txt"},
+	}, map[string]string{"d/f.txt": "hello"})
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractFile(archive, out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(out, "d", "l"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("d/l = %q, %v, want hello through the link", data, err)
+	}
+	if data, err = ioutil.ReadFile(filepath.Join(out, "h")); err != nil || string(data) != "hello" {
+		t.Fatalf("h = %q, %v, want hello", data, err)
+	}
+	fi, err := os.Stat(filepath.Join(out, "d", "f.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 || fi.ModTime().Equal(mtime) == false {
+		t.Errorf("d/f.txt has mode %v and time %v, want 0600 and %v", fi.Mode().Perm(), fi.ModTime(), mtime)
+	}
+	if fi, err = os.Stat(filepath.Join(out, "d")); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0750 || fi.ModTime().Equal(mtime) == false {
+		t.Errorf("d has mode %v and time %v, want 0750 and %v", fi.Mode().Perm(), fi.ModTime(), mtime)
+	}
+}
+
+// TestExtractReadOnlyDir extracts files into directories whose stored
+// modes don't let anyone write to them.
+func TestExtractReadOnlyDir(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1500000000, 0)
+	archive := writeTarGz(t, dir, []*tar.Header{
+		{Name: "ro/", Typeflag: tar.TypeDir, Mode: 0555, ModTime: mtime},
+		{Name: "ro/sub/", Typeflag: tar.TypeDir, Mode: 0500, ModTime: mtime},
+		{Name: "ro/sub/f.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime},
+		{Name: "ro/g.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime},
+	}, map[string]string{"ro/sub/f.txt": "f", "ro/g.txt": "g"})
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer filepath.Walk(out, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(p, 0755)
+		}
+		return nil
+	})
+	if err := extractFile(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"ro": 0555, "ro/sub": 0500} {
+		fi, err := os.Stat(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want || fi.ModTime().Equal(mtime) == false {
+			t.Errorf("%s has mode %v and time %v, want %v and %v", name, fi.Mode().Perm(), fi.ModTime(), want, mtime)
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(out, "ro", "sub", "f.txt")); err != nil || string(data) != "f" {
+		t.Errorf("ro/sub/f.txt = %q, %v, want f", data, err)
+	}
+}
+
+func TestExtractTraversal(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+		want    string
+	}{
+		{"parent name", []*tar.Header{
+			{Name: "../pwn.txt", Typeflag: tar.TypeReg},
+		}, "leaves the extraction directory"},
+		{"absolute name", []*tar.Header{
+			{Name: "/tmp/pwn.txt", Typeflag: tar.TypeReg},
+		}, "absolute entry name"},
+		{"link out", []*tar.Header{
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		}, "leaves the extraction directory"},
+		{"absolute link", []*tar.Header{
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		}, "leaves the extraction directory"},
+		{"hard link out", []*tar.Header{
+			{Name: "h", Typeflag: tar.TypeLink, Linkname: "../secret"},
+		}, "leaves the extraction directory"},
+		// Each link stays inside on its own, but a/b resolves to the
+		// parent of out through a.
+		{"chained links", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "b/pwn.txt", Typeflag: tar.TypeReg},
+		}, "is a symbolic link"},
+		{"file through link", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a/pwn.txt", Typeflag: tar.TypeReg},
+		}, "is a symbolic link"},
+		{"directory through link", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a/", Typeflag: tar.TypeDir, Mode: 0777},
+		}, "is a symbolic link"},
+		{"hard link through link", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "h", Typeflag: tar.TypeLink, Linkname: "a/x"},
+		}, "is a symbolic link"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := writeTarGz(t, dir, tt.entries, map[string]string{})
+			out := filepath.Join(dir, "x", "out")
+			if err := os.MkdirAll(out, 0755); err != nil {
+				t.Fatal(err)
+			}
+			err := extractFile(archive, out)
+			if err == nil || strings.Contains(err.Error(), tt.want) == false {
+				t.Fatalf("extractFile = %v, want an error containing %q", err, tt.want)
+			}
+			for _, p := range []string{filepath.Join(dir, "x", "pwn.txt"), filepath.Join(dir, "pwn.txt")} {
+				if _, err := os.Lstat(p); err == nil {
+					t.Errorf("%s was written outside the extraction directory", p)
+				}
+			}
+			if fi, err := os.Stat(filepath.Join(dir, "x")); err == nil && fi.Mode().Perm() != 0755 {
+				t.Errorf("the parent of out has mode %v, want 0755", fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExtractDryRun(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, dir, []*tar.Header{
+		{Name: "f.txt", Typeflag: tar.TypeReg},
+	}, map[string]string{"f.txt": "hello"})
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := runAio(t, nil, dir, "", "-dry-run", "-x", "-C", "out", archive)
+	if r.status == 0 {
+		t.Errorf("aio -dry-run -x succeeded, want it refused")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "out", "f.txt")); err == nil {
+		t.Errorf("aio -dry-run -x extracted f.txt")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// readFileList returns the paths listed in the manifest p, or standard
+// input for "-", one per line or separated by NUL with -null. Blank
+// entries and lines starting with # are skipped.
+func readFileList(p string) ([]string, error) {
+	var data []byte
+	var err error
+	if p == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte("\n")
+	if *null == true {
+		sep = []byte{0}
+	}
+	var list []string
+	for _, entry := range bytes.Split(data, sep) {
+		name := string(entry)
+		if *null == false {
+			name = strings.TrimSuffix(name, "\r")
+		}
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		list = append(list, name)
+	}
+	return list, nil
+}
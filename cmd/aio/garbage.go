@@ -0,0 +1,98 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/pedroalbanese/aio"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipTrailingReader decodes gzip members one at a time and stops, with a
+// warning, at the first bytes after a member that don't start another one,
+// as GNU gzip does. Those bytes aren't covered by any check, so whatever
+// was appended to the file, or a member whose header was overwritten, goes
+// unnoticed but for the warning; -ignore-trailing-garbage is off by
+// default for that reason.
+type gzipTrailingReader struct {
+	br   *bufio.Reader
+	z    *gzip.Reader
+	done bool
+}
+
+func newGzipTrailingReader(r io.Reader) (*gzipTrailingReader, error) {
+	br := bufio.NewReader(r)
+	z, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	z.Multistream(false)
+	return &gzipTrailingReader{br: br, z: z}, nil
+}
+
+func (g *gzipTrailingReader) Read(p []byte) (int, error) {
+	for {
+		if g.done == true {
+			return 0, io.EOF
+		}
+		n, err := g.z.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		h, _ := g.br.Peek(len(gzipMagic))
+		switch {
+		case len(h) == 0:
+			g.done = true
+		case bytes.Equal(h, gzipMagic):
+			if err := g.z.Reset(g.br); err != nil {
+				return n, err
+			}
+			g.z.Multistream(false)
+		default:
+			// Reading the rest keeps the input side from blocking.
+			junk, err := io.Copy(ioutil.Discard, g.br)
+			if err != nil {
+				return n, err
+			}
+			warnf("warning: ignoring %d bytes of trailing garbage after the gzip data", junk)
+			g.done = true
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (g *gzipTrailingReader) Close() error {
+	return g.z.Close()
+}
+
+// detectAlgorithm is aio.DetectAlgorithm, except that gzip streams are
+// decoded by a gzipTrailingReader with -ignore-trailing-garbage, and zstd
+// streams with the dictionary of -dict.
+func detectAlgorithm(r io.Reader) (string, io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	h, _ := br.Peek(aio.HeaderSize)
+	switch algo := aio.Identify(h); {
+	case algo == "gzip" && *ignoreJunk == true:
+		z, err := newGzipTrailingReader(br)
+		if err != nil {
+			return "gzip", nil, err
+		}
+		return "gzip", z, nil
+	case algo == "zstd" && len(zstdDecoderOptions) > 0:
+		z, err := newDecompressor(br, "zstd")
+		if err != nil {
+			return "zstd", nil, err
+		}
+		return "zstd", z.(io.ReadCloser), nil
+	}
+	return aio.DetectAlgorithm(br)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// maxLine bounds the length of a line scanned by grepFiles.
+const maxLine = 1 << 30
+
+// grepFiles decompresses each file on the fly and prints the lines
+// matching re, prefixed with the file name when there is more than one
+// file. With -count only the number of matching lines is printed. Like
+// grep, it returns 0 when a line matched, 1 when none did and 2 when a
+// file couldn't be read.
+func grepFiles(re *regexp.Regexp, files []string) (status int) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status = 1
+	for _, file := range files {
+		prefix := ""
+		if len(files) > 1 {
+			prefix = file + ":"
+		}
+		currentInput = file
+		found, r, err := grepFile(re, file, prefix)
+		printResult(r)
+		if err != nil {
+			warnf("%s: %s", file, err)
+			status = 2
+			continue
+		}
+		if found == true && status == 1 {
+			status = 0
+		}
+	}
+	return status
+}
+
+func grepFile(re *regexp.Regexp, file, prefix string) (found bool, r fileResult, err error) {
+	r = fileResult{Name: file, Status: "failed", Algorithm: "-", InSize: inputSize(file)}
+	z, err := openDecompressed(file)
+	if err != nil {
+		return false, r, err
+	}
+	defer z.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	sc := bufio.NewScanner(z)
+	sc.Buffer(make([]byte, bufferSize), maxLine)
+	var n int
+	for sc.Scan() {
+		if re.Match(sc.Bytes()) == false {
+			continue
+		}
+		n++
+		if *count == false {
+			fmt.Fprintf(out, "%s%s\n", prefix, sc.Bytes())
+		}
+	}
+	if *count == true {
+		fmt.Fprintf(out, "%s%d\n", prefix, n)
+	}
+	r.Algorithm, r.OutSize = z.algo, z.n
+	if err = sc.Err(); err == nil {
+		r.Status = "ok"
+	}
+	return n > 0, r, err
+}
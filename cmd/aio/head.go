@@ -0,0 +1,138 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// headFile writes the first n bytes, or lines with -lines, of the
+// decompressed file to standard output and stops decoding there.
+func headFile(file string, n int64) error {
+	z, err := openDecompressed(file)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	if *lines == false {
+		_, err = io.CopyN(os.Stdout, z, n)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+
+	br := bufio.NewReader(z)
+	for ; n > 0; n-- {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			n++
+		} else if err == io.EOF {
+			_, err = os.Stdout.Write(line)
+			return err
+		} else if err != nil {
+			return err
+		}
+		if _, err = os.Stdout.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tailFile writes the last n bytes, or lines with -lines, of the
+// decompressed file to standard output. The whole stream is decoded but
+// only the tail is kept in memory.
+func tailFile(file string, n int64) error {
+	z, err := openDecompressed(file)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	if *lines == true {
+		return tailLines(z, n)
+	}
+	return tailBytes(z, n)
+}
+
+// tailBytes keeps the last n bytes of r in a ring buffer, which grows
+// with the data up to n bytes.
+func tailBytes(r io.Reader, n int64) error {
+	if n == 0 {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}
+	var ring []byte
+	var pos int
+	buf := make([]byte, 32*1024)
+	for {
+		m, err := r.Read(buf)
+		p := buf[:m]
+		if room := n - int64(len(ring)); room > 0 {
+			k := len(p)
+			if int64(k) > room {
+				k = int(room)
+			}
+			ring = append(ring, p[:k]...)
+			p = p[k:]
+		}
+		for len(p) > 0 {
+			c := copy(ring[pos:], p)
+			p = p[c:]
+			pos += c
+			if pos == len(ring) {
+				pos = 0
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stdout.Write(ring[pos:]); err != nil {
+		return err
+	}
+	_, err := os.Stdout.Write(ring[:pos])
+	return err
+}
+
+// tailLines keeps the last n lines of r in a ring of lines, which grows
+// with the data up to n lines.
+func tailLines(r io.Reader, n int64) error {
+	if n == 0 {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}
+	var ring [][]byte
+	var pos int
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && int64(len(ring)) < n {
+			ring = append(ring, line)
+		} else if len(line) > 0 {
+			ring[pos] = line
+			pos = (pos + 1) % len(ring)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := range ring {
+		if _, err := os.Stdout.Write(ring[(pos+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+func TestTail(t *testing.T) {
+	dir := t.TempDir()
+	writeCompressed(t, dir, "f.gz", "gzip")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-tail", "5"}, "ffix\n"},
+		{[]string{"-tail", "18"}, suffixInput},
+		{[]string{"-tail", "19"}, suffixInput},
+		// A count far beyond the input mustn't be allocated up front.
+		{[]string{"-tail", "10000000000"}, suffixInput},
+		{[]string{"-tail", "1", "-lines"}, suffixInput},
+		{[]string{"-tail", "10000000000", "-lines"}, suffixInput},
+	}
+	for _, tt := range tests {
+		r := mustRun(t, dir, append(tt.args, "f.gz")...)
+		if r.stdout != tt.want {
+			t.Errorf("aio %v f.gz = %q, want %q", tt.args, r.stdout, tt.want)
+		}
+	}
+}
+
+// TestTailWraps keeps the tail of an input many times its size, so the
+// ring wraps around.
+func TestTailWraps(t *testing.T) {
+	dir := t.TempDir()
+	var in bytes.Buffer
+	for i := 0; in.Len() < 200<<10; i++ {
+		fmt.Fprintf(&in, "line %d\n", i)
+	}
+	data, err := aio.Compress("gzip", aio.DefaultLevel, in.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.gz"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := in.Bytes()[in.Len()-1000:]
+	if r := mustRun(t, dir, "-tail", "1000", "f.gz"); r.stdout != string(want) {
+		t.Errorf("aio -tail 1000 = %d bytes, want the last 1000 of the input", len(r.stdout))
+	}
+	lines := bytes.SplitAfter(in.Bytes(), []byte("\n"))
+	want = bytes.Join(lines[len(lines)-1-3:], nil)
+	if r := mustRun(t, dir, "-tail", "3", "-lines", "f.gz"); r.stdout != string(want) {
+		t.Errorf("aio -tail 3 -lines = %q, want %q", r.stdout, want)
+	}
+}
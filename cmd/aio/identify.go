@@ -0,0 +1,159 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pedroalbanese/aio"
+)
+
+// identifyFiles prints the format of each file from its magic bytes, the
+// original size and whether the header parses. The size is the stored one
+// when the format has it, else it is computed by decoding the whole file,
+// unless -fast is given. It reports whether any file could not be opened.
+func identifyFiles(files []string) (failed bool) {
+	for _, file := range files {
+		currentInput = file
+		line, r, err := identifyFile(file)
+		printResult(r)
+		if err != nil {
+			warnf("%s: %s", file, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: %s\n", file, line)
+	}
+	return failed
+}
+
+// identifyFile returns the line printed for file, and its result for
+// -summary: failed when the header doesn't parse or the data doesn't
+// decode, skipped when file isn't compressed, with the original size when
+// it is known.
+func identifyFile(file string) (string, fileResult, error) {
+	r := fileResult{Name: file, Status: "failed", Algorithm: "-"}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", r, err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil {
+		r.InSize = fi.Size()
+	}
+	algo, z, err := aio.DetectAlgorithm(f)
+	if err == aio.ErrUnknownFormat {
+		r.Status = "skipped"
+		return "not a supported compressed file", r, nil
+	}
+	if algo == "" {
+		return "", r, err
+	}
+	r.Algorithm = algo
+	if err != nil {
+		return fmt.Sprintf("%s, invalid header: %s", algo, err), r, nil
+	}
+	defer z.Close()
+	r.Status = "ok"
+	size := "?"
+	if n, ok := storedSize(f, algo); ok == true {
+		size = strconv.FormatUint(n, 10) + " (stored)"
+		r.OutSize = int64(n)
+	} else if *fast == false {
+		n, err := io.Copy(ioutil.Discard, z)
+		if err != nil {
+			size = fmt.Sprintf("unknown (%s)", decodeError(err, algo, "", nil))
+			r.Status = "failed"
+		} else {
+			size = strconv.FormatInt(n, 10) + " (computed)"
+			r.OutSize = n
+		}
+	}
+	line := fmt.Sprintf("%s, original size %s", algo, size)
+	if sum := storedChecksum(f, algo); sum != "" {
+		line += ", " + sum
+	}
+	if m := readMeta(file); algo == "zstd" && m != nil {
+		line += fmt.Sprintf(", name %q, mtime %s", m.Name, time.Unix(m.MTime, 0).UTC().Format(time.RFC3339))
+	}
+	return line + ", header ok", r, nil
+}
+
+// storedChecksum returns the checksum from the trailer of a gzip (CRC32)
+// or zlib (Adler32) file, for the last gzip member only, or "" for other
+// formats. A file too short to hold a trailer is <truncated>.
+func storedChecksum(f *os.File, algo string) string {
+	name, min := "crc32", int64(18)
+	var tail [8]byte
+	b := tail[:]
+	if algo == "zlib" {
+		name, min, b = "adler32", 6, tail[:4]
+	} else if algo != "gzip" {
+		return ""
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Mode().IsRegular() == false {
+		return ""
+	}
+	if fi.Size() < min {
+		return name + " <truncated>"
+	}
+	if _, err := f.ReadAt(b, fi.Size()-int64(len(b))); err != nil {
+		return ""
+	}
+	if algo == "zlib" {
+		return fmt.Sprintf("%s %08x", name, binary.BigEndian.Uint32(b))
+	}
+	return fmt.Sprintf("%s %08x", name, binary.LittleEndian.Uint32(b))
+}
+
+// storedSize returns the uncompressed size recorded in the headers of f:
+// the ISIZE trailer of gzip (modulo 4 GiB, for the last member only), the
+// content size of the first zstd frame or the lzma header size field.
+func storedSize(f *os.File, algo string) (uint64, bool) {
+	switch algo {
+	case "gzip":
+		fi, err := f.Stat()
+		if err != nil || fi.Size() < 18 {
+			return 0, false
+		}
+		var b [4]byte
+		if _, err := f.ReadAt(b[:], fi.Size()-4); err != nil {
+			return 0, false
+		}
+		return uint64(binary.LittleEndian.Uint32(b[:])), true
+	case "zstd":
+		// Skippable frames, such as -embed-meta's, may come first.
+		var off int64
+		for {
+			var b [zstd.HeaderMaxSize]byte
+			n, _ := f.ReadAt(b[:], off)
+			var h zstd.Header
+			if h.Decode(b[:n]) != nil {
+				return 0, false
+			}
+			if h.Skippable == true {
+				off += int64(h.HeaderSize) + int64(h.SkippableSize)
+				continue
+			}
+			return h.FrameContentSize, h.HasFCS
+		}
+	case "lzma":
+		var b [13]byte
+		if _, err := f.ReadAt(b[:], 0); err != nil {
+			return 0, false
+		}
+		n := binary.LittleEndian.Uint64(b[5:])
+		// All ones means the size is unknown and an end marker is used.
+		return n, n != 1<<64-1
+	}
+	return 0, false
+}
@@ -4,48 +4,205 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 
-	"compress/gzip"
-	"compress/zlib"
 	"github.com/dsnet/compress/bzip2"
-	"github.com/klauspost/compress/s2"
 	"github.com/klauspost/compress/zstd"
-	"github.com/pedroalbanese/brotli"
-	"github.com/pedroalbanese/lzma"
+	"github.com/pedroalbanese/aio"
 	"github.com/pedroalbanese/xz"
 )
 
 var (
-	algorithm  = flag.String("a", "gzip", "compression algorithm: bzip2, lzma, xz, zlib, zstd")
+	algorithm  = flag.String("a", "gzip", "compression algorithm")
+	contentEnc = flag.String("content-encoding", "", "select the algorithm by its HTTP Content-Encoding `token`: br, deflate, gzip, x-gzip, zstd")
 	stdout     = flag.Bool("c", false, "write on standard output, keep original files unchanged")
 	decompress = flag.Bool("d", false, "decompress; see also -c and -k")
 	force      = flag.Bool("f", false, "force overwrite of output file")
+	appendOut  = flag.Bool("append", false, "append a new member to an existing output of the same format (brotli, lzma and zlib can't)")
+	update     = flag.Bool("update", false, "skip compressing FILE when its output exists and is newer, replace the output when it is older")
+	rename     = flag.Bool("rename", false, "when the output file exists, write FILE.1.ext, FILE.2.ext, ... instead")
 	help       = flag.Bool("h", false, "print this help message")
 	keep       = flag.Bool("k", false, "keep original files unchanged")
-	suffix     = flag.String("s", "gz", "use provided suffix on compressed files")
-	cores      = flag.Int("cores", 1, "number of cores to use for parallelization")
-	stdin bool
+	deref      = flag.Bool("dereference", false, "process the target of a FILE that is a symbolic link, naming the output after the link and removing the link; links are skipped otherwise, unless -c is given")
+	chmod      = flag.String("chmod", "", "set the permissions of output files to the octal `mode`")
+	chown      = flag.String("chown", "", "set the owner of output files to `user[:group]`, as names or numeric ids")
+	backupDir  = flag.String("backup-dir", "", "move original files into `directory`, under their path relative to the working directory, instead of removing them")
+	quiet      = flag.Bool("q", false, "suppress warnings and per-file errors, report failure only in the exit status")
+	suffix     = flag.String("s", "gz", "use provided suffix on compressed files; selects the algorithm when -a is not given")
+	cores      = flag.Int("cores", 1, "number of cores to use for parallelization, 0 for all; also sets the zstd and s2 encoder concurrency and the xz workers, which compress blocks of three times the dictionary, 24 MiB at the default level")
+	output     = flag.String("o", "", "write output to the provided file, or upload it to an http or https URL")
+	uploadVia  = flag.String("upload-method", "PUT", "HTTP `method` of the upload when -o is a URL")
+	nameTmpl   = flag.String("name-template", "", "name compressed files from a `template` of {name}, {ext}, {algo} and {suffix} (default \"{name}{ext}.{suffix}\")")
+	outputDir  = flag.String("O", "", "write output files into the provided directory")
+	showProg   = flag.Bool("progress", false, "show the progress of the input on standard error when it is a terminal")
+	summary    = flag.Bool("summary", false, "print the status (ok, skipped or failed), input and output sizes, ratio and algorithm of each file on standard error, marked auto when -a auto picked it, then a line of totals")
+	summaryFmt = flag.String("summary-format", "text", "format of -summary: text, or json, one object per line that also has the level and duration")
+	logFormat  = flag.String("log-format", "text", "format of the record of the file on standard error: text, or json, the same as -summary -summary-format json")
+	showCRC    = flag.Bool("show-crc", false, "with -summary, also print the CRC-32 of the uncompressed data")
+	dryRun     = flag.Bool("dry-run", false, "print what would be done without reading or writing files")
+	syncOut    = flag.Bool("sync", false, "flush output files and their directory to disk before removing or moving the input")
+	verify     = flag.Bool("verify", false, "decompress the output and compare it with the input before removing it; reads the data twice")
+	checksum   = flag.String("checksum", "", "write a sidecar checksum of the output and check it on decompress: crc32, sha1, sha256")
+	pipeTo     = flag.String("pipe-to", "", "also feed the output to the shell `command`, e.g. sha256sum, and fail when it fails")
+	cat        = flag.Bool("cat", false, "decompress each FILE in order to standard output, detecting its algorithm")
+	identify   = flag.Bool("identify", false, "print the format, stored original size and header status of each FILE")
+	fast       = flag.Bool("fast", false, "with -identify, print ? instead of decoding files whose format doesn't store the original size")
+	caps       = flag.Bool("capabilities", false, "print the level range, suffix, extensions and features of every algorithm")
+	capsFmt    = flag.String("capabilities-format", "text", "format of -capabilities: text, json")
+	selftest   = flag.Bool("selftest", false, "compress and decompress sample data in memory with every algorithm and level range, and print the results")
+	entropyOf  = flag.Bool("entropy", false, "print the entropy in bits per byte of the contents of each FILE and the algorithm auto would pick")
+	grep       = flag.String("grep", "", "print the lines of each decompressed FILE matching the regular expression `pattern`")
+	ignoreCase = flag.Bool("ignore-case", false, "with -grep, match without regard to case")
+	count      = flag.Bool("count", false, "with -grep, print the number of matching lines of each FILE")
+	compareRef = flag.String("compare-with", "", "decompress FILE and compare it with the `reference` file, printing the first differing byte")
+	filesFrom  = flag.String("files-from", "", "read the FILE arguments from `manifest`, one per line, or standard input for -")
+	null       = flag.Bool("null", false, "with -files-from, the manifest is separated by NUL characters")
+	keepGoing  = flag.Bool("keep-going", false, "with -cat, continue with the next file after an error")
+	head       = flag.Int64("head", 0, "decompress only the first `N` bytes of FILE to standard output")
+	tail       = flag.Int64("tail", 0, "decompress FILE and write its last `N` bytes to standard output")
+	lines      = flag.Bool("lines", false, "with -head or -tail, count lines instead of bytes")
+	buffer     = flag.String("buffer", "256K", "I/O buffer `size` in bytes, with optional K, M or G suffix")
+	limitRate  = flag.String("limit-rate", "", "limit the compressed side of the I/O to `rate` bytes per second, with optional K, M or G suffix")
+	ignoreJunk = flag.Bool("ignore-trailing-garbage", false, "decode a gzip stream followed by bytes that aren't gzip, with a warning; the bytes go unchecked")
+	memLimit   = flag.String("mem-limit", "", "refuse to decompress xz and lzma streams whose dictionary exceeds `size` bytes, with optional K, M or G suffix")
+	check      = flag.String("check", "crc64", "xz integrity check: crc32, crc64, sha256, none")
+	blockSize  = flag.Int("block-size", bzip2.DefaultCompression, "bzip2 block size in 100k units, 1-9")
+	brotliWin  = flag.Int("brotli-window", 0, "brotli window size as a power of two, 10-24 (default automatic)")
+	skipComp   = flag.Bool("skip-compressed", false, "skip files that are already compressed, unless forced")
+	mapExt     = flag.String("map-ext", "", "comma separated `.ext=algorithm` pairs adding or overriding file suffixes, e.g. .tgz=gzip")
+	compExt    = flag.String("compressed-ext", "7z,br,bz2,gz,jpeg,jpg,lzma,mp3,mp4,png,rar,s2,tgz,webp,xz,zip,zst,zz", "comma separated extensions treated as compressed by -skip-compressed")
+	keepSmall  = flag.Bool("keep-if-smaller", false, "keep the input uncompressed when compressing would make it larger")
+	skipFlag   = flag.String("skip-bytes", "", "start reading the input `N` bytes in, with optional K, M or G suffix; the compressed input with -d")
+	readFlag   = flag.String("read-bytes", "", "read at most `N` bytes of the input, with optional K, M or G suffix")
+	split      = flag.String("split", "", "split the output into volumes of at most `size` bytes, named FILE.001, FILE.002, ...")
+	seekable   = flag.Bool("seekable", false, "write zstd in the seekable format of independent 1 MiB frames, at a slightly lower ratio")
+	gzComment  = flag.String("comment", "", "gzip header comment, in Latin-1")
+	gzOS       = flag.Int("os", 255, "gzip header OS byte, 0-255; 255 is unknown")
+	gzMtime    = flag.Int64("mtime", 0, "gzip header modification time in Unix `seconds`, 0 for none")
+	rsyncable  = flag.Bool("rsyncable", false, "make gzip output rsync friendly")
+	compat     = flag.Bool("compat", false, "write the gzip header as GNU gzip does: the name and modification time of FILE and OS byte 3 (Unix); the deflate data still differs")
+	levelName  = flag.String("level-name", "default", "compression level: fast, default, best")
+	optimize   = flag.String("optimize", "", "favor size, speed or balanced: sets the default level-name, and with -a auto picks the algorithm by compressing a sample with each")
+	levels     = flag.String("levels", "", "comma separated `algorithm=level` pairs overriding level-name for those algorithms, e.g. gzip=6,zstd=19")
+	store      = flag.Bool("store", false, "store without compressing where the format allows it (gzip, zlib, s2, snappy), else use the fast level")
+	recompress = flag.String("recompress", "", "decompress FILE, detecting its format, and compress it again with `algorithm`")
+	extract    = flag.Bool("x", false, "decompress FILE, or standard input, and extract the tar archive it holds")
+	extractDir = flag.String("C", ".", "with -x, extract into `directory`")
+	webAssets  = flag.Bool("web-assets", false, "write a best level variant of each FILE for every -variants suffix next to it, keeping FILE and skipping compressed types")
+	variants   = flag.String("variants", "br,gz", "comma separated suffixes written by -web-assets, e.g. br,gz,zst")
+	embedMeta  = flag.Bool("embed-meta", false, "store the name and modification time of FILE in a zstd skippable frame; with -d, restore them")
+	timeout    = flag.Duration("timeout", 0, "abort after `duration`, e.g. 90s or 5m, removing the partial output")
+	dict       = flag.String("dict", "", "zstd dictionary file; the same dictionary is required to decompress")
+	stdin      bool
+	bufferSize int
+	splitSize  int64
+	skipBytes  int64
+	readBytes  int64
+	extMap     = map[string]string{}
+	levelMap   = map[string]int{}
+	limiter    *rateLimiter
+	memLimitSz int64
+	outMode    os.FileMode
+	outUID     int
+	outGID     int
+	xzCheck    byte
+
+	zstdEncoderOptions []zstd.EOption
+	zstdDecoderOptions []zstd.DOption
+	long               windowLog
+	// inputMeta is the metadata -embed-meta, or -compat in the gzip
+	// header, stores, nil for standard input.
+	inputMeta *fileMeta
+	// bySuffix is set when -d decodes FILE with the algorithm its suffix
+	// selects rather than detecting it from the stream.
+	bySuffix bool
+	// dryRunAlgo tells how the algorithm would be picked when -dry-run
+	// can't know it without reading the input.
+	dryRunAlgo string
+	// plainCRC hashes the uncompressed side of the data for -show-crc.
+	plainCRC hash.Hash32
 )
 
+func init() {
+	flag.Var(&long, "long", "zstd long distance matching; -long=N sets the window log (default 27)")
+}
+
+// windowLog is the zstd window size as a power of two. It may be given as
+// a bare -long, which selects defaultWindowLog.
+type windowLog int
+
+const defaultWindowLog = 27
+
+func (w *windowLog) String() string {
+	return strconv.Itoa(int(*w))
+}
+
+func (w *windowLog) Set(s string) error {
+	if s == "true" {
+		*w = defaultWindowLog
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*w = windowLog(n)
+	return nil
+}
+
+func (w *windowLog) IsBoolFlag() bool {
+	return true
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [OPTION]... [FILE]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -c [OPTION]... FILE...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -cat [OPTION]... [FILE]...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -identify FILE...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -entropy [FILE]...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -x [-C DIRECTORY] [OPTION]... [FILE]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -selftest\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -grep PATTERN [OPTION]... [FILE]...\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "Compress or uncompress FILE (by default, compress FILE in-place).\n\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\nWith no FILE, or when FILE is -, read standard input.\n")
+	fmt.Fprintf(os.Stderr, "With -c and several FILEs, their contents are compressed as one stream, each decompressed first with -recompress.\n")
+	fmt.Fprintf(os.Stderr, "With -d and no -a, the algorithm is detected from the stream header, or else taken from\n")
+	fmt.Fprintf(os.Stderr, "the suffix of FILE, as for brotli, which has no magic bytes; -map-ext suffixes always select it.\n")
+	fmt.Fprintf(os.Stderr, "With -d, a known suffix of FILE is stripped whatever algorithm decodes it.\n")
+	fmt.Fprintf(os.Stderr, "The -skip-bytes and -read-bytes window applies to the joined volumes of a split set,\n")
+	fmt.Fprintf(os.Stderr, "and with -split to the input before it is compressed and cut into volumes.\n")
+	fmt.Fprintf(os.Stderr, "When compressing, AIO_ALGORITHM sets the algorithm without -a or -s, and AIO_LEVEL\n")
+	fmt.Fprintf(os.Stderr, "the level without -level-name or -levels: fast, default, best or a level of the algorithm.\n")
 }
 
 func exit(msg string) {
 	usage()
 	fmt.Fprintln(os.Stderr)
-	log.Fatalf("%s: check args: %s\n\n", os.Args[0], msg)
+	fatalf("%s: check args: %s\n\n", os.Args[0], msg)
+}
+
+// warnf logs a non-fatal message unless -q was given.
+func warnf(format string, v ...interface{}) {
+	if *quiet == false {
+		log.Printf(format, v...)
+	}
 }
 
 func setByUser(name string) (isSet bool) {
@@ -57,11 +214,535 @@ func setByUser(name string) (isSet bool) {
 	return
 }
 
+// decoding reports whether the command decodes its input rather than
+// compressing it.
+func decoding() bool {
+	return *decompress == true || *cat == true || *identify == true || *extract == true || *compareRef != "" || setByUser("grep") == true || setByUser("head") == true || setByUser("tail") == true
+}
+
+// verifyOutput decompresses the file at path, or its volumes with -split,
+// and checks that the SHA-256 of its contents matches sum.
+func verifyOutput(path string, sum []byte) error {
+	var f io.ReadCloser
+	var err error
+	if *split != "" {
+		f, err = openVolumes(path)
+	} else {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z, err := newDecompressor(f, *algorithm)
+	if err != nil {
+		return err
+	}
+	if c, ok := z.(io.Closer); ok {
+		defer c.Close()
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, z); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return errors.New("decompressed data doesn't match the input")
+	}
+	return nil
+}
+
+func newHash(name string) hash.Hash {
+	switch name {
+	case "crc32":
+		return crc32.NewIEEE()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// writeSidecar writes sum next to p in the format of sha256sum and
+// friends, so the sidecar can also be checked with those tools.
+func writeSidecar(p string, sum []byte) error {
+	line := fmt.Sprintf("%x  %s\n", sum, path.Base(p))
+	return ioutil.WriteFile(p+"."+*checksum, []byte(line), 0644)
+}
+
+// checkSidecar hashes the file, or split set, at path and compares it
+// with its sidecar checksum file, if there is one. It returns the sidecar path when one
+// was found and matched.
+func checkSidecar(path string) (string, error) {
+	sidecar := volumeBase(path) + "." + *checksum
+	data, err := ioutil.ReadFile(sidecar)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("error: %s is empty", sidecar)
+	}
+	f, err := openInput(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash(*checksum)
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if fmt.Sprintf("%x", h.Sum(nil)) != strings.ToLower(fields[0]) {
+		return "", fmt.Errorf("error: %s doesn't match %s checksum", path, sidecar)
+	}
+	return sidecar, nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"), strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// copyBuffer copies src to dst through a buffer of -buffer bytes, until
+// runCtx is done. Both ends are wrapped so that their ReadFrom and WriteTo
+// methods don't bypass the buffer.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, bufferSize)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, &ctxReader{runCtx, src}, buf)
+}
+
+const (
+	// sampleSize is the amount of input compressed by -skip-compressed to
+	// estimate the ratio of a file.
+	sampleSize = 1 << 20
+	// skipRatio is the output to input ratio at or above which a file is
+	// considered already compressed.
+	skipRatio = 0.98
+)
+
+// alreadyCompressed reports whether the file at path has one of the
+// -compressed-ext extensions or doesn't shrink when compressing a sample
+// of it with the selected algorithm.
+func alreadyCompressed(p string) bool {
+	if hasCompressedExt(p) == true {
+		return true
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer f.Close()
+	sample, err := ioutil.ReadAll(io.LimitReader(f, sampleSize))
+	if err != nil {
+		fatal(err.Error())
+	}
+	if len(sample) == 0 {
+		return false
+	}
+	var buf bytes.Buffer
+	z, err := newCompressor(&buf, *algorithm, 0)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if _, err = z.Write(sample); err != nil {
+		fatal(err.Error())
+	}
+	if err = z.Close(); err != nil {
+		fatal(err.Error())
+	}
+	return float64(buf.Len()) >= skipRatio*float64(len(sample))
+}
+
+// hasCompressedExt reports whether the file at path has one of the
+// -compressed-ext extensions.
+func hasCompressedExt(p string) bool {
+	ext := strings.TrimPrefix(path.Ext(p), ".")
+	for _, e := range strings.Split(*compExt, ",") {
+		if ext != "" && strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeOutput removes the compressed output at p, or its volumes with
+// -split.
+func removeOutput(p string) error {
+	if *split != "" {
+		return removeVolumes(p)
+	}
+	return os.Remove(p)
+}
+
+// defaultSuffix returns the suffix of files compressed with algo.
+func defaultSuffix(algo string) string {
+	return codecs[algo].Suffix
+}
+
+// suffixAlgorithm returns the algorithm mapped to suffix by -map-ext or
+// whose files go by suffix, or "" if there is none.
+func suffixAlgorithm(suffix string) string {
+	if algo, ok := extMap[suffix]; ok == true {
+		return algo
+	}
+	for _, c := range codecs {
+		if c.Suffix == suffix {
+			return c.Name
+		}
+		for _, ext := range c.Extensions {
+			if ext == suffix {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+func validAlgorithm(name string) bool {
+	_, ok := codecs[name]
+	return ok
+}
+
+// detectFile sniffs the header of the file at path and returns its
+// compression algorithm.
+func detectFile(p string) (algo string, bySuffix bool) {
+	algo, err := sniffFile(p)
+	if s := suffixDecoder(p, algo); s != "" {
+		return s, true
+	}
+	if err == aio.ErrUnknownFormat {
+		exit(fmt.Sprintf("can't detect format of %s, provide algorithm with -a", p))
+	}
+	if err != nil {
+		fatal(err.Error())
+	}
+	return algo, false
+}
+
+// suffixDecoder returns the algorithm that decodes file going by its
+// suffix: the -map-ext entry for it, whatever the stream looks like, or
+// else the algorithm of a built-in suffix when the header matched none,
+// detected being "". Brotli has no magic bytes, so its suffix is all
+// there is to go by. It returns "" when the header decides.
+func suffixDecoder(file, detected string) string {
+	ext := strings.TrimPrefix(path.Ext(volumeBase(file)), ".")
+	if algo, ok := extMap[ext]; ok == true {
+		return algo
+	}
+	if detected == "" {
+		return suffixAlgorithm(ext)
+	}
+	return ""
+}
+
+// sniffFile returns the compression algorithm of the file at path.
+func sniffFile(path string) (string, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	algo, z, err := aio.DetectAlgorithm(f)
+	if err != nil {
+		return "", err
+	}
+	z.Close()
+	return algo, nil
+}
+
+// sameFile reports whether the paths a and b name the same file, whether
+// spelled the same or not, as an absolute and a relative path or through
+// a link.
+func sameFile(a, b string) bool {
+	if path.Clean(a) == path.Clean(b) {
+		return true
+	}
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// freeName returns p, or the first of p.1.ext, p.2.ext, ... where ext is
+// the extension of p, that doesn't exist yet.
+func freeName(p string) string {
+	ext := path.Ext(p)
+	stem := strings.TrimSuffix(p, ext)
+	name := p
+	for n := 1; ; n++ {
+		check := name
+		if *split != "" {
+			check = volumePath(name, 1)
+		}
+		if _, err := os.Lstat(check); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%d%s", stem, n, ext)
+	}
+}
+
+// templateName expands -name-template for the input file p: {name} is
+// the file name without its extension, {ext} the extension with its dot,
+// {algo} the algorithm and {suffix} the suffix.
+func templateName(p string) string {
+	base := path.Base(p)
+	ext := path.Ext(base)
+	name := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", ext,
+		"{algo}", *algorithm,
+		"{suffix}", *suffix,
+	).Replace(*nameTmpl)
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		exit(fmt.Sprintf("name template %s gives %q, not a file name", *nameTmpl, name))
+	}
+	return name
+}
+
+// checkSpecial refuses an input p that isn't a regular file, unless it
+// is a named pipe or a device read with -c: those are streams, so there is
+// no output file next to them and they are never removed.
+func checkSpecial(p string) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		fatal(err.Error())
+	}
+	mode := fi.Mode()
+	kind := "device"
+	switch {
+	case mode.IsRegular() == true:
+		return
+	case mode.IsDir() == true:
+		exit(fmt.Sprintf("%s is not a regular file", p))
+	case mode&os.ModeSocket != 0:
+		exit(fmt.Sprintf("%s is a socket, not a regular file", p))
+	case mode&os.ModeNamedPipe != 0:
+		kind = "named pipe"
+	case mode&os.ModeDevice == 0:
+		exit(fmt.Sprintf("%s is not a regular file", p))
+	}
+	if *stdout == false {
+		exit(fmt.Sprintf("%s is a %s, it can only be read as a stream with -c", p, kind))
+	}
+}
+
+// inputSize returns the size of the input, or 0 when it isn't a regular
+// file or a split set. A URL is read in place of standard input, which
+// says nothing of its size.
+func inputSize(p string) (size int64) {
+	if urlInput != "" {
+		return 0
+	}
+	if stdin == true {
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+		return 0
+	}
+	list := []string{p}
+	if joinsVolumes(p) == true {
+		list = volumes(volumeBase(p))
+	}
+	for _, v := range list {
+		fi, err := os.Stat(v)
+		if err != nil || fi.Mode().IsRegular() == false {
+			return 0
+		}
+		size += fi.Size()
+	}
+	return size
+}
+
+// upToDate reports whether the output of in, or its first volume with
+// -split, exists and was modified after in.
+func upToDate(in, out string) bool {
+	if *split != "" {
+		out = volumePath(out, 1)
+	}
+	fi, err := os.Stat(in)
+	if err != nil {
+		return false
+	}
+	fo, err := os.Stat(out)
+	return err == nil && fo.ModTime().After(fi.ModTime())
+}
+
+// checkOutput resolves -rename for outFilePath and checks that it can be
+// written, removing it with -f or -update. With -dry-run a refusal is printed and
+// reported as a conflict instead.
+func checkOutput(outFilePath string) (string, bool) {
+	var conflict bool
+	// With -update, an output that reaches here is older than the input.
+	overwrite := *force == true || *update == true
+	if *rename == true {
+		outFilePath = freeName(outFilePath)
+	}
+	checkPath := outFilePath
+	if *split != "" {
+		checkPath = volumePath(outFilePath, 1)
+	}
+	f, err := os.Lstat(checkPath)
+	if err != nil && f != nil {
+		fatal(err.Error())
+	}
+	if f != nil && !f.IsDir() {
+		if *appendOut == true {
+			if algo, err := sniffFile(checkPath); err != nil || algo != *algorithm {
+				exit(fmt.Sprintf("outFile %s isn't a %s file, can't append", checkPath, *algorithm))
+			}
+		} else if overwrite == true && *dryRun == true {
+			fmt.Printf("would overwrite %s\n", checkPath)
+		} else if overwrite == true && *split != "" {
+			err = removeVolumes(outFilePath)
+			if err != nil {
+				fatal(err.Error())
+			}
+		} else if overwrite == true {
+			err = os.Remove(outFilePath)
+			if err != nil {
+				fatal(err.Error())
+			}
+		} else if *dryRun == true {
+			fmt.Printf("would refuse to overwrite %s without -f\n", checkPath)
+			conflict = true
+		} else {
+			exit(fmt.Sprintf("outFile %s exists. use force to overwrite", checkPath))
+		}
+	} else if f != nil && *dryRun == true {
+		fmt.Printf("would refuse to write %s, not a regular file\n", checkPath)
+		conflict = true
+	} else if f != nil {
+		exit(fmt.Sprintf("outFile %s exists and is not a regular file", checkPath))
+	}
+	return outFilePath, conflict
+}
+
+// dryRunReport prints the files that would be created and removed for
+// the parsed arguments, algo naming the algorithm or, when it takes
+// reading the input to know, how it would be picked.
+func dryRunReport(inFilePath, outFilePath, algo string) {
+	in, out := inFilePath, outFilePath
+	if urlInput != "" {
+		in = urlInput
+	} else if stdin == true {
+		in = "stdin"
+	}
+	if *stdout == true {
+		out = "stdout"
+	} else if *split != "" {
+		out = volumePath(out, 1) + ", ..."
+	}
+	action := "compress"
+	if *recompress != "" {
+		action = "recompress"
+	}
+	if *decompress == true {
+		action = "decompress"
+		if stdin == true && setByUser("a") == false {
+			algo = "the detected algorithm"
+		}
+	}
+	fmt.Printf("would %s %s to %s with %s\n", action, in, out, algo)
+	if *checksum != "" && *decompress == false {
+		fmt.Printf("would create %s.%s\n", out, *checksum)
+	}
+	if *pipeTo != "" {
+		fmt.Printf("would pipe the output to %s\n", *pipeTo)
+	}
+	if *stdout == false && *keep == false && stdin == false && *backupDir != "" {
+		fmt.Printf("would move %s to %s\n", in, backupPath(in))
+	} else if *stdout == false && *keep == false && stdin == false {
+		fmt.Printf("would remove %s\n", in)
+	}
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	// Help comes first, whatever the other flags.
 	if *help == true {
 		usage()
-		log.Fatal(0)
+		os.Exit(0)
+	}
+	if *null == true && *filesFrom == "" {
+		exit("null is only used with files-from")
+	}
+	if *filesFrom != "" {
+		list, err := readFileList(*filesFrom)
+		if err != nil {
+			fatal(err.Error())
+		}
+		if len(list) == 0 {
+			exit(fmt.Sprintf("no files listed in %s", *filesFrom))
+		}
+		// The listed names join the arguments as if given on the
+		// command line; -- keeps names starting with - from being flags.
+		flag.CommandLine.Parse(append([]string{"--"}, append(flag.Args(), list...)...))
+	}
+	if *recompress != "" {
+		if setByUser("a") == true || *decompress == true {
+			exit("recompress detects the source format and sets the target, without -a or -d")
+		}
+		if *nameTmpl != "" || *skipComp == true || *appendOut == true {
+			exit("recompress can't be used with name-template, skip-compressed or append")
+		}
+		flag.Set("a", *recompress)
+	}
+	if *contentEnc != "" {
+		algo, ok := contentEncodings[strings.ToLower(*contentEnc)]
+		if ok == false {
+			exit(fmt.Sprintf("unsupported content encoding %s", *contentEnc))
+		}
+		if setByUser("a") == true {
+			exit("content-encoding selects the algorithm, it can't be used with -a or recompress")
+		}
+		flag.Set("a", algo)
+	}
+	if *mapExt != "" {
+		for _, pair := range strings.Split(*mapExt, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			ext := strings.TrimPrefix(strings.TrimSpace(kv[0]), ".")
+			if len(kv) != 2 || ext == "" || validAlgorithm(strings.TrimSpace(kv[1])) == false {
+				exit(fmt.Sprintf("invalid map-ext entry %s, want .ext=algorithm", pair))
+			}
+			extMap[ext] = strings.TrimSpace(kv[1])
+		}
+	}
+	if setByUser("s") == true && setByUser("a") == false && *decompress == false {
+		algo := suffixAlgorithm(*suffix)
+		if algo == "" {
+			exit(fmt.Sprintf("suffix .%s doesn't match a known algorithm, provide it with -a", *suffix))
+		}
+		*algorithm = algo
+	}
+	// Assigned rather than set, so that decoding still detects the
+	// algorithm and -s still selects it.
+	if env := os.Getenv("AIO_ALGORITHM"); env != "" && setByUser("a") == false && setByUser("s") == false && decoding() == false {
+		*algorithm = env
+	}
+	if validAlgorithm(*algorithm) == false && *algorithm != "auto" {
+		exit(fmt.Sprintf("unknown algorithm %s", *algorithm))
 	}
 	//if *stdout == true && *suffix != "gz" {
 	if *stdout == true && setByUser("s") == true {
@@ -73,32 +754,525 @@ func main() {
 	if *stdout == true && *keep == true {
 		exit("stdout set, keep is redundant")
 	}
-	if flag.NArg() > 1 {
+	if (*chmod != "" || *chown != "") && *stdout == true {
+		exit("stdout set, chmod and chown need an output file")
+	}
+	if *chmod != "" {
+		m, err := parseMode(*chmod)
+		if err != nil {
+			exit(err.Error())
+		}
+		outMode = m
+	}
+	if *chown != "" {
+		uid, gid, err := parseOwner(*chown)
+		if err != nil {
+			exit(fmt.Sprintf("invalid owner %s: %s", *chown, err))
+		}
+		outUID, outGID = uid, gid
+	}
+	if *backupDir != "" && (*stdout == true || *keep == true) {
+		exit("backup-dir can't be used with stdout or keep, the original files are kept")
+	}
+	if *stdout == true && (*output != "" || *outputDir != "") {
+		exit("stdout set, output file and directory not used")
+	}
+	if *verify == true && *decompress == true {
+		exit("verify only applies to compression")
+	}
+	if *verify == true && *stdout == true {
+		exit("stdout set, verify needs an output file")
+	}
+	if *checksum != "" && newHash(*checksum) == nil {
+		exit(fmt.Sprintf("unknown checksum %s", *checksum))
+	}
+	if *checksum != "" && *decompress == false && *stdout == true {
+		exit("stdout set, checksum needs an output file")
+	}
+	if *keepSmall == true && *decompress == true {
+		exit("keep-if-smaller only applies to compression")
+	}
+	if *keepSmall == true && *stdout == true {
+		exit("stdout set, keep-if-smaller needs an output file")
+	}
+	if *split != "" && *decompress == true {
+		exit("split only applies to compression, volumes are joined automatically with -d")
+	}
+	if *split != "" && *stdout == true {
+		exit("stdout set, split needs an output file")
+	}
+	if *skipFlag != "" {
+		n, err := parseSize(*skipFlag)
+		if err != nil || n < 0 {
+			exit(fmt.Sprintf("invalid skip-bytes count %s", *skipFlag))
+		}
+		skipBytes = n
+	}
+	if *readFlag != "" {
+		n, err := parseSize(*readFlag)
+		if err != nil || n < 0 {
+			exit(fmt.Sprintf("invalid read-bytes count %s", *readFlag))
+		}
+		readBytes = n
+	}
+	if (*skipFlag != "" || *readFlag != "") && (*appendOut == true || *recompress != "" || *embedMeta == true || *keepSmall == true) {
+		exit("skip-bytes and read-bytes can't be used with append, recompress, embed-meta or keep-if-smaller")
+	}
+	if *split != "" {
+		n, err := parseSize(*split)
+		if err != nil || n < 1 {
+			exit(fmt.Sprintf("invalid split size %s", *split))
+		}
+		splitSize = n
+	}
+	if *limitRate != "" {
+		n, err := parseSize(*limitRate)
+		if err != nil || n < 1 {
+			exit(fmt.Sprintf("invalid rate %s", *limitRate))
+		}
+		limiter = newRateLimiter(n)
+	}
+	if *timeout < 0 {
+		exit(fmt.Sprintf("invalid timeout %s", *timeout))
+	}
+	if *timeout > 0 {
+		startTimeout(*timeout)
+	}
+	if *memLimit != "" {
+		n, err := parseSize(*memLimit)
+		if err != nil || n < 1 {
+			exit(fmt.Sprintf("invalid memory limit %s", *memLimit))
+		}
+		memLimitSz = n
+	}
+	if *nameTmpl != "" {
+		if *decompress == true || *output != "" {
+			exit("name-template only names compressed files, without -o")
+		}
+		for _, v := range regexp.MustCompile(`{[^}]*}`).FindAllString(*nameTmpl, -1) {
+			if v != "{name}" && v != "{ext}" && v != "{algo}" && v != "{suffix}" {
+				exit(fmt.Sprintf("unknown placeholder %s in name template", v))
+			}
+		}
+	}
+	if *summaryFmt != "text" && *summaryFmt != "json" {
+		exit(fmt.Sprintf("unknown summary format %s", *summaryFmt))
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		exit(fmt.Sprintf("unknown log format %s", *logFormat))
+	}
+	if *logFormat == "json" {
+		if *summaryFmt != "json" && setByUser("summary-format") == true {
+			exit("log-format json can't be used with summary-format text")
+		}
+		*summary, *summaryFmt = true, "json"
+	}
+	if *showCRC == true && *summary == false {
+		exit("show-crc is only used with summary")
+	}
+	if *showCRC == true {
+		plainCRC = crc32.NewIEEE()
+	}
+	if setByUser("summary-format") == true && *summary == false {
+		exit("summary-format is only used with summary")
+	}
+	defer printTotals()
+	if *appendOut == true {
+		if *decompress == true || *stdout == true {
+			exit("append only applies to compressing to a file")
+		}
+		if codecs[*algorithm].concat == false {
+			exit(fmt.Sprintf("%s streams can't be concatenated, append isn't supported", *algorithm))
+		}
+		if *force == true || *rename == true || *split != "" || *verify == true || *checksum != "" || *keepSmall == true {
+			exit("append can't be used with force, rename, split, verify, checksum or keep-if-smaller")
+		}
+	}
+	if *update == true && (*decompress == true || *stdout == true || *rename == true || *appendOut == true) {
+		exit("update only applies to compressing to a file, without rename or append")
+	}
+	if *rename == true && (*force == true || *stdout == true) {
+		exit("rename can't be used with force or stdout")
+	}
+	if *output != "" && *outputDir != "" {
+		exit("output file and output directory are mutually exclusive")
+	}
+	if isURL(*output) && (*split != "" || *appendOut == true || *rename == true || *update == true || *verify == true || *checksum != "" || *keepSmall == true || *syncOut == true || *chmod != "" || *chown != "" || *embedMeta == true) {
+		exit("a URL output can't be used with split, append, rename, update, verify, checksum, keep-if-smaller, sync, chmod, chown or embed-meta")
+	}
+	if setByUser("upload-method") == true && isURL(*output) == false {
+		exit("upload-method is only used when the output is a URL")
+	}
+	if strings.HasPrefix(*output, "s3://") {
+		exit("s3 URLs aren't supported, use an https URL accepting a streamed upload")
+	}
+	concat := flag.NArg() > 1 && *stdout == true && *decompress == false
+	// Standard input can only be read once, and only to standard output.
+	var dashes int
+	for _, file := range flag.Args() {
+		if file == "-" {
+			dashes++
+		}
+	}
+	if dashes > 1 {
+		exit("standard input can be given only once")
+	}
+	if concat == true && (*skipFlag != "" || *readFlag != "") {
+		exit("skip-bytes and read-bytes apply to a single input, not to -c with several files")
+	}
+	if *algorithm == "auto" {
+		if decoding() == true || concat == true || *appendOut == true || *webAssets == true {
+			exit("auto only applies to compressing a single input, without append or web-assets")
+		}
+	}
+	if flag.NArg() > 1 && concat == false && *cat == false && *identify == false && *entropyOf == false && setByUser("grep") == false && *webAssets == false {
 		exit("too many file, provide at most one file at a time or check order of flags")
 	}
-	if *cores < 1 || *cores > 32 {
+	if *cores < 0 {
 		exit("invalid number of cores")
 	}
+	if *cores == 0 {
+		*cores = runtime.NumCPU()
+	}
+	if n, err := parseSize(*buffer); err != nil || n < 1 || n > 1<<30 {
+		exit(fmt.Sprintf("invalid buffer size %s", *buffer))
+	} else {
+		bufferSize = int(n)
+	}
+	if setByUser("check") == true && *algorithm != "xz" {
+		exit("check is only supported by xz")
+	}
+	switch *check {
+	case "crc32":
+		xzCheck = xz.CRC32
+	case "crc64":
+		xzCheck = xz.CRC64
+	case "sha256":
+		xzCheck = xz.SHA256
+	case "none":
+		xzCheck = xz.None
+	default:
+		exit(fmt.Sprintf("unknown xz check %s", *check))
+	}
+	if setByUser("block-size") == true && *algorithm != "bzip2" {
+		exit("block size is only supported by bzip2")
+	}
+	if *blockSize < bzip2.BestSpeed || *blockSize > bzip2.BestCompression {
+		exit("invalid bzip2 block size, must be between 1 and 9")
+	}
+	if *brotliWin != 0 && *algorithm != "brotli" {
+		exit("brotli window is only supported by brotli")
+	}
+	if *brotliWin != 0 && (*brotliWin < 10 || *brotliWin > 24) {
+		exit("invalid brotli window, must be between 10 and 24")
+	}
+	if *seekable == true && *algorithm != "zstd" {
+		exit("seekable is only supported by zstd")
+	}
+	if *seekable == true && *decompress == true {
+		exit("seekable only applies to compression, seekable streams decompress as regular zstd")
+	}
+	if env := os.Getenv("AIO_LEVEL"); env != "" && decoding() == false && setByUser("level-name") == false && *levels == "" && *store == false {
+		// A level name, or a level of the algorithm as with -levels.
+		switch {
+		case env == "fast" || env == "default" || env == "best":
+			*levelName = env
+		case *algorithm == "auto":
+			exit("a numeric AIO_LEVEL needs an algorithm, auto picks it later")
+		default:
+			*levels = *algorithm + "=" + env
+		}
+	}
+	if *levelName != "fast" && *levelName != "default" && *levelName != "best" {
+		exit(fmt.Sprintf("unknown level name %s", *levelName))
+	}
+	if *optimize != "" && *optimize != "size" && *optimize != "speed" && *optimize != "balanced" {
+		exit(fmt.Sprintf("unknown optimize goal %s, want size, speed or balanced", *optimize))
+	}
+	if *optimize != "" && *decompress == true {
+		exit("optimize only applies to compression")
+	}
+	if *optimize != "" && setByUser("level-name") == false && *store == false {
+		*levelName = map[string]string{"size": "best", "speed": "fast", "balanced": "default"}[*optimize]
+	}
+	if setByUser("variants") == true && *webAssets == false {
+		exit("variants is only used with web-assets")
+	}
+	if *webAssets == true {
+		if *decompress == true || *stdout == true || *output != "" || *outputDir != "" || *recompress != "" {
+			exit("web-assets writes its variants next to each FILE, without -d, -c, -o, -O or -recompress")
+		}
+		if flag.NArg() == 0 {
+			exit("web-assets needs at least one file")
+		}
+		for _, v := range strings.Split(*variants, ",") {
+			if suffixAlgorithm(v) == "" {
+				exit(fmt.Sprintf("unknown variant suffix %s", v))
+			}
+		}
+		if setByUser("level-name") == false && *store == false {
+			*levelName = "best"
+		}
+	}
+	if *store == true && setByUser("level-name") == true {
+		exit("store and level-name are mutually exclusive")
+	}
+	if *store == true && *decompress == true {
+		exit("store only applies to compression")
+	}
+	if *store == true {
+		// Codecs without a stored mode use their fastest level.
+		*levelName = "fast"
+	}
+	if *levels != "" && (*store == true || *decompress == true) {
+		exit("levels only applies to compression, without store")
+	}
+	if *levels != "" {
+		for _, pair := range strings.Split(*levels, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			algo := strings.TrimSpace(kv[0])
+			if len(kv) != 2 || validAlgorithm(algo) == false {
+				exit(fmt.Sprintf("invalid levels entry %s, want algorithm=level", pair))
+			}
+			c := codecs[algo]
+			if c.MaxLevel == 0 {
+				exit(fmt.Sprintf("%s has no levels, use level-name", algo))
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil || n < c.MinLevel || n > c.MaxLevel {
+				exit(fmt.Sprintf("invalid %s level %s, must be between %d and %d", algo, strings.TrimSpace(kv[1]), c.MinLevel, c.MaxLevel))
+			}
+			levelMap[algo] = n
+		}
+	}
+	// zstd has four encoder levels, the zstd scale maps onto them.
+	zstdLevel := zstd.EncoderLevel(level("zstd", int(zstd.SpeedFastest), int(zstd.SpeedDefault), int(zstd.SpeedBestCompression)))
+	if n, ok := levelMap["zstd"]; ok {
+		zstdLevel = zstd.EncoderLevelFromZstd(n)
+	}
+	zstdEncoderOptions = append(zstdEncoderOptions, zstd.WithEncoderLevel(zstdLevel))
+	// An empty input is still written as a frame, so that it decodes.
+	zstdEncoderOptions = append(zstdEncoderOptions, zstd.WithZeroFrames(true))
+	if (setByUser("comment") || setByUser("os") || setByUser("mtime")) && (*algorithm != "gzip" || *decompress == true) {
+		exit("comment, os and mtime only apply to gzip compression")
+	}
+	if *gzOS < 0 || *gzOS > 255 {
+		exit("invalid os byte, must be between 0 and 255")
+	}
+	if *gzMtime < 0 {
+		exit("invalid mtime, must not be negative")
+	}
+	if latin1(*gzComment) == false {
+		exit("comment must be Latin-1 without NUL characters")
+	}
+	if *compat == true && (*algorithm != "gzip" || *decompress == true) {
+		exit("compat only applies to gzip compression")
+	}
+	if *rsyncable == true && *algorithm != "gzip" {
+		exit("rsyncable is only supported by gzip")
+	}
+	if *embedMeta == true && *decompress == false && *algorithm != "zstd" {
+		exit("embed-meta is only supported by zstd")
+	}
+	if *embedMeta == true && (*seekable == true || *appendOut == true) {
+		exit("embed-meta can't be used with seekable or append")
+	}
+	// Without -a, the algorithm of the input is only known once it is
+	// detected, and -dict and -long are used when it is zstd.
+	if *dict != "" && *algorithm != "zstd" && (decoding() == false || setByUser("a") == true) {
+		exit("dictionary is only supported by zstd")
+	}
+	if *dict != "" {
+		data, err := ioutil.ReadFile(*dict)
+		if err != nil {
+			fatal(err.Error())
+		}
+		zstdEncoderOptions = append(zstdEncoderOptions, zstd.WithEncoderDict(data))
+		zstdDecoderOptions = append(zstdDecoderOptions, zstd.WithDecoderDicts(data))
+	}
+	if setByUser("long") == true && *algorithm != "zstd" && (decoding() == false || setByUser("a") == true) {
+		exit("long distance matching is only supported by zstd")
+	}
+	if setByUser("long") == true {
+		if long < 10 || long > 29 {
+			exit("invalid window log, must be between 10 and 29")
+		}
+		zstdEncoderOptions = append(zstdEncoderOptions, zstd.WithWindowSize(1<<uint(long)))
+		// The decoder accepts windows up to zstd.MaxWindowSize by default,
+		// which a smaller limit would only lower.
+		if uint64(1)<<uint(long) > zstd.MaxWindowSize {
+			zstdDecoderOptions = append(zstdDecoderOptions, zstd.WithDecoderMaxWindow(1<<uint(long)))
+		}
+	}
 
 	runtime.GOMAXPROCS(*cores)
 
+	if setByUser("capabilities-format") == true && *caps == false {
+		exit("capabilities-format is only used with capabilities")
+	}
+	if *capsFmt != "text" && *capsFmt != "json" {
+		exit(fmt.Sprintf("unknown capabilities format %s", *capsFmt))
+	}
+	if *caps == true {
+		printCapabilities()
+		return
+	}
+	if *selftest == true {
+		if selfTest() == true {
+			os.Exit(1)
+		}
+		return
+	}
+	if *fast == true && *identify == false {
+		exit("fast is only used with identify")
+	}
+	if *identify == true {
+		if flag.NArg() == 0 {
+			exit("identify needs at least one file")
+		}
+		if identifyFiles(flag.Args()) == true {
+			exitStatus(1)
+		}
+		return
+	}
+	if *entropyOf == true {
+		if entropyFiles(flag.Args()) == true {
+			exitStatus(1)
+		}
+		return
+	}
+	if (*ignoreCase == true || *count == true) && setByUser("grep") == false {
+		exit("ignore-case and count are only used with grep")
+	}
+	if setByUser("grep") == true {
+		pattern := *grep
+		if *ignoreCase == true {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			exit(fmt.Sprintf("invalid pattern: %s", err))
+		}
+		exitStatus(grepFiles(re, flag.Args()))
+	}
+	if concat == true && *cat == false && *identify == false && setByUser("grep") == false {
+		if *dryRun == true {
+			verb := "compress"
+			if *recompress != "" {
+				verb = "recompress"
+			}
+			fmt.Printf("would %s %s to stdout as one stream with %s\n", verb, strings.Join(flag.Args(), ", "), *algorithm)
+			return
+		}
+		if concatFiles(flag.Args()) == true {
+			exitStatus(1)
+		}
+		return
+	}
+	if *compareRef != "" {
+		if flag.NArg() != 1 {
+			exit("compare-with needs exactly one file to decompress")
+		}
+		exitStatus(compareFile(*compareRef, flag.Args()[0]))
+	}
+	if *cat == true {
+		if catFiles(flag.Args()) == true {
+			exitStatus(1)
+		}
+		return
+	}
+	if setByUser("C") == true && *extract == false {
+		exit("C is only used with x")
+	}
+	if *extract == true {
+		if *dryRun == true {
+			exit("dry-run can't be used with x, the archive has to be read to know its entries")
+		}
+		if flag.NArg() > 1 {
+			exit("x needs one file to extract")
+		}
+		file := "-"
+		if flag.NArg() == 1 {
+			file = flag.Args()[0]
+		}
+		if err := extractFile(file, *extractDir); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+	if *webAssets == true {
+		if writeWebAssets(flag.Args()) == true {
+			exitStatus(1)
+		}
+		return
+	}
+	if setByUser("head") == true && setByUser("tail") == true {
+		exit("head and tail are mutually exclusive")
+	}
+	if *head < 0 || *tail < 0 {
+		exit("head and tail need a positive count")
+	}
+	if *lines == true && setByUser("head") == false && setByUser("tail") == false {
+		exit("lines is only used with head or tail")
+	}
+	if setByUser("head") == true || setByUser("tail") == true {
+		file := "-"
+		if flag.NArg() == 1 {
+			file = flag.Args()[0]
+		}
+		var err error
+		if setByUser("head") == true {
+			err = headFile(file, *head)
+		} else {
+			err = tailFile(file, *tail)
+		}
+		if err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+
 	var inFilePath string
 	var outFilePath string
-	if flag.NArg() == 0 || flag.NArg() == 1 && flag.Args()[0] == "-" { // parse args: read from stdin
-		if *stdout != true {
-			exit("reading from stdin, can write only to stdout")
+	var conflict bool
+	var meta *fileMeta
+	if flag.NArg() == 0 || flag.NArg() == 1 && (flag.Args()[0] == "-" || isURL(flag.Args()[0])) { // parse args: read from stdin
+		// A URL is read as a stream, like standard input.
+		currentInput = "-"
+		source := "stdin"
+		if flag.NArg() == 1 && isURL(flag.Args()[0]) {
+			urlInput = flag.Args()[0]
+			source = urlInput
+		}
+		if *stdout != true && *output == "" {
+			exit(fmt.Sprintf("reading from %s, can write only to stdout or the file given with -o", source))
 		}
 		//if *suffix != "gz" {
 		if setByUser("s") == true {
-			exit("reading from stdin, suffix not needed")
+			exit(fmt.Sprintf("reading from %s, suffix not needed", source))
 		}
 		stdin = true
+		if *algorithm == "auto" && urlInput != "" {
+			exit("auto can't read ahead of a URL, provide the algorithm with -a")
+		}
+		if *algorithm == "auto" && *dryRun == true {
+			dryRunAlgo = autoDryRun
+		} else if *algorithm == "auto" {
+			resolveAuto("-")
+		}
+		if *stdout == false {
+			if *outputDir != "" || *keepSmall == true {
+				exit(fmt.Sprintf("reading from %s, output directory and keep-if-smaller need an input file", source))
+			}
+			outFilePath, conflict = checkOutput(*output)
+		}
 
 	} else if flag.NArg() == 1 { // parse args: read from file
 		inFilePath = flag.Args()[0]
+		currentInput = inFilePath
 		f, err := os.Lstat(inFilePath)
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
 		}
 		if f == nil {
 			exit(fmt.Sprintf("file %s not found", inFilePath))
@@ -106,213 +1280,475 @@ func main() {
 		if !!f.IsDir() {
 			exit(fmt.Sprintf("%s is not a regular file", inFilePath))
 		}
+		// Like gzip, a link is only followed when asked to or when
+		// nothing is removed.
+		if f.Mode()&os.ModeSymlink != 0 && *deref == false && *stdout == false {
+			if *dryRun == true {
+				fmt.Printf("would skip %s (symbolic link)\n", inFilePath)
+			} else {
+				warnf("skipping %s (symbolic link), use -dereference to process its target", inFilePath)
+				printSummary(inFilePath, "skipped", 0, 0)
+			}
+			return
+		}
+		checkSpecial(inFilePath)
+		// A dry run doesn't read the input, so what only its contents
+		// tell is reported as such.
+		if *algorithm == "auto" && *dryRun == true {
+			dryRunAlgo = autoDryRun
+			*suffix = "{suffix}"
+		} else if *algorithm == "auto" {
+			resolveAuto(inFilePath)
+		}
+		if *decompress == true && setByUser("a") == false && *dryRun == true {
+			if algo := suffixDecoder(inFilePath, "?"); algo != "" {
+				*algorithm, bySuffix = algo, true
+			} else {
+				dryRunAlgo = "the detected algorithm"
+			}
+		} else if *decompress == true && setByUser("a") == false {
+			*algorithm, bySuffix = detectFile(inFilePath)
+		}
 
 		if *stdout == false {
 			if *suffix == "" {
 				exit("suffix can't be an empty string")
 			}
 
-			if *algorithm == "lzma" {
-				*suffix = "lzma"
-			} else if *algorithm == "gzip" {
-				*suffix = "gz"
-			} else if *algorithm == "brotli" {
-				*suffix = "br"
-			} else if *algorithm == "zlib" {
-				*suffix = "zz"
-			} else if *algorithm == "bzip2" {
-				*suffix = "bz2"
-			} else if *algorithm == "s2" {
-				*suffix = "s2"
-			} else if *algorithm == "zstd" {
-				*suffix = "zst"
-			} else if *algorithm == "xz" {
-				*suffix = "xz"
-			}
-
-			if *decompress == true {
-				outFileDir, outFileName := path.Split(inFilePath)
+			if *skipComp == true && *decompress == false && *force == false && *dryRun == true && hasCompressedExt(inFilePath) == false {
+				fmt.Printf("would skip %s if a sample of it doesn't shrink\n", inFilePath)
+			} else if *skipComp == true && *decompress == false && *force == false && alreadyCompressed(inFilePath) {
+				if *dryRun == true {
+					fmt.Printf("would skip %s (already compressed)\n", inFilePath)
+				} else {
+					warnf("skipping %s (already compressed)", inFilePath)
+					printSummary(inFilePath, "skipped", inputSize(inFilePath), 0)
+				}
+				return
+			}
+
+			if setByUser("s") == false && dryRunAlgo != autoDryRun {
+				*suffix = defaultSuffix(*algorithm)
+				// The algorithm decodes, a known suffix of the file names.
+				ext := strings.TrimPrefix(path.Ext(volumeBase(inFilePath)), ".")
+				if *decompress == true && suffixAlgorithm(ext) != "" {
+					*suffix = ext
+				}
+			}
+
+			if *decompress == true && *embedMeta == true && *dryRun == true && *output == "" {
+				fmt.Printf("would name the output after the name stored in %s, if there is one\n", inFilePath)
+			} else if *decompress == true && *embedMeta == true {
+				meta = readMeta(inFilePath)
+			}
+			if *output != "" {
+				outFilePath = *output
+			} else if meta != nil && meta.Name != "" {
+				outFilePath = path.Join(path.Dir(volumeBase(inFilePath)), meta.Name)
+			} else if *decompress == true {
+				outFileDir, outFileName := path.Split(volumeBase(inFilePath))
 				if strings.HasSuffix(outFileName, "."+*suffix) {
 					if len(outFileName) > len("."+*suffix) {
 						nstr := strings.SplitN(outFileName, ".", len(outFileName))
 						estr := strings.Join(nstr[0:len(nstr)-1], ".")
 						outFilePath = outFileDir + estr
 					} else {
-						log.Fatalf("error: can't strip suffix .%s from file %s", *suffix, inFilePath)
+						fatalf("error: can't strip suffix .%s from file %s", *suffix, inFilePath)
 					}
 				} else {
-					exit(fmt.Sprintf("file %s doesn't have suffix .%s", inFilePath, *suffix))
+					// Decoding doesn't depend on the name, so only the
+					// output name falls back.
+					outFilePath = volumeBase(inFilePath) + ".out"
+					if dryRunAlgo == "" {
+						warnf("warning: %s doesn't have suffix .%s, writing %s", inFilePath, *suffix, outFilePath)
+					}
 				}
 
+			} else if *nameTmpl != "" {
+				outFilePath = path.Join(path.Dir(inFilePath), templateName(inFilePath))
+			} else if *recompress != "" {
+				// file.gz becomes file.zst, a file without a known suffix
+				// keeps its name.
+				outFilePath = inFilePath
+				if ext := path.Ext(inFilePath); suffixAlgorithm(strings.TrimPrefix(ext, ".")) != "" {
+					outFilePath = strings.TrimSuffix(inFilePath, ext)
+				}
+				outFilePath += "." + *suffix
 			} else {
 				outFilePath = inFilePath + "." + *suffix
 			}
 
-			f, err = os.Lstat(outFilePath)
-			if err != nil && f != nil {
-				log.Fatal(err.Error())
-			}
-			if f != nil && !f.IsDir() {
-				if *force == true {
-					err = os.Remove(outFilePath)
-					if err != nil {
-						log.Fatal(err.Error())
+			if *outputDir != "" {
+				if *dryRun == true {
+					if _, err := os.Stat(*outputDir); os.IsNotExist(err) {
+						fmt.Printf("would create directory %s\n", *outputDir)
 					}
+				} else if err = os.MkdirAll(*outputDir, 0755); err != nil {
+					fatal(err.Error())
+				}
+				outFilePath = path.Join(*outputDir, path.Base(outFilePath))
+			}
+			if sameFile(outFilePath, inFilePath) == true {
+				exit(fmt.Sprintf("outFile %s is the input file", outFilePath))
+			}
+
+			if *update == true && *force == false && upToDate(inFilePath, outFilePath) == true {
+				if *dryRun == true {
+					fmt.Printf("would skip %s (%s is up to date)\n", inFilePath, outFilePath)
 				} else {
-					exit(fmt.Sprintf("outFile %s exists. use force to overwrite", outFilePath))
+					warnf("skipping %s, %s is up to date", inFilePath, outFilePath)
+					printSummary(inFilePath, "skipped", inputSize(inFilePath), 0)
 				}
-			} else if f != nil {
-				exit(fmt.Sprintf("outFile %s exists and is not a regular file", outFilePath))
+				return
 			}
+			outFilePath, conflict = checkOutput(outFilePath)
+		}
+	}
+
+	if *backupDir != "" && stdin == false {
+		if err := checkBackupDir(inFilePath); err != nil {
+			exit(err.Error())
 		}
 	}
 
+	if *dryRun == true {
+		algo := *algorithm
+		if dryRunAlgo != "" {
+			algo = dryRunAlgo
+		}
+		dryRunReport(inFilePath, outFilePath, algo)
+		if conflict == true {
+			exitStatus(1)
+		}
+		return
+	}
+
 	pr, pw := io.Pipe()
 	defer pr.Close()
 	defer pw.Close()
 
+	var sidecar string
+	if *decompress && *checksum != "" && stdin == false {
+		var err error
+		sidecar, err = checkSidecar(inFilePath)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	var inSize, outSize int64
+	var bar *progressBar
+	if *showProg == true && isTerminal(os.Stderr) == true {
+		bar = startProgress(inputSize(inFilePath))
+	}
+
 	if *decompress {
 		// read from inFile into pw
 		go func() {
 			defer pw.Close()
-			var inFile *os.File
+			var inFile io.ReadCloser
 			var err error
-			if stdin == true {
+			if urlInput != "" {
+				inFile, err = openURL(urlInput)
+			} else if stdin == true {
 				inFile = os.Stdin
 			} else {
-				inFile, err = os.Open(inFilePath)
+				inFile, err = openInput(inFilePath)
 			}
-			defer inFile.Close()
 			if err != nil {
-				log.Fatal(err.Error())
+				fatal(err.Error())
 			}
+			defer inFile.Close()
 
-			_, err = io.Copy(pw, inFile)
+			var src io.Reader = inFile
+			if *skipFlag != "" || *readFlag != "" {
+				if src, err = windowInput(inFile); err != nil {
+					fatal(err.Error())
+				}
+			}
+			if limiter != nil {
+				src = &limitedReader{r: src, l: limiter}
+			}
+			if bar != nil {
+				src = bar.reader(src)
+			}
+			_, err = copyBuffer(pw, src)
 			if err != nil {
-				log.Fatal(err.Error())
+				fatal(err.Error())
 			}
 
 		}()
 
 		// write into outFile from z
 		defer pr.Close()
+		var src io.Reader = pr
+		if memLimitSz > 0 {
+			var err error
+			src, err = checkMemLimit(pr, memLimitSz)
+			if err != nil {
+				fatal(err.Error())
+			}
+		}
 		var z io.Reader
-		if *algorithm == "lzma" {
-			z = lzma.NewReader(pr)
-		} else if *algorithm == "gzip" {
-			z, _ = gzip.NewReader(pr)
-		} else if *algorithm == "brotli" {
-			z = brotli.NewReader(pr)
-		} else if *algorithm == "zlib" {
-			z, _ = zlib.NewReader(pr)
-		} else if *algorithm == "bzip2" {
-			z, _ = bzip2.NewReader(pr, nil)
-		} else if *algorithm == "s2" {
-			z = s2.NewReader(pr)
-		} else if *algorithm == "zstd" {
-			z, _ = zstd.NewReader(pr)
-		} else if *algorithm == "xz" {
-			z, _ = xz.NewReader(pr)
-		}
-		var outFile *os.File
+		if setByUser("a") == false && bySuffix == false {
+			algo, zr, err := detectAlgorithm(src)
+			if err != nil {
+				fatal(decodeError(err, algo, "", nil).Error())
+			}
+			defer zr.Close()
+			z = &decodeReader{r: zr, algo: algo}
+		} else {
+			src, h := peekHeader(src)
+			zr, err := newDecompressor(src, *algorithm)
+			if err != nil {
+				fatal(decodeError(err, *algorithm, algorithmSource(inFilePath), h).Error())
+			}
+			if c, ok := zr.(io.Closer); ok {
+				defer c.Close()
+			}
+			z = &decodeReader{r: zr, algo: *algorithm, from: algorithmSource(inFilePath), h: h}
+		}
+		var outFile io.WriteCloser
 		var err error
 		if *stdout == true {
 			outFile = os.Stdout
 		} else {
-			outFile, err = os.Create(outFilePath)
+			outFile, err = createOutput(outFilePath)
+			if isURL(outFilePath) == false {
+				trackPartial(outFilePath)
+			}
 		}
 		defer outFile.Close()
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
 		}
 
-		_, err = io.Copy(outFile, z)
+		var dst io.Writer = outFile
+		var pipe *pipeCmd
+		if *pipeTo != "" {
+			if pipe, err = startPipe(*pipeTo); err != nil {
+				fatal(err.Error())
+			}
+			dst = io.MultiWriter(dst, pipe)
+		}
+		if plainCRC != nil {
+			dst = io.MultiWriter(dst, plainCRC)
+		}
+		outSize, err = copyBuffer(dst, z)
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
+		}
+		if u, ok := outFile.(*urlWriter); ok {
+			if err := u.Close(); err != nil {
+				fatal(err.Error())
+			}
+		}
+		if *syncOut == true && *stdout == false {
+			if err := syncOutput(outFile, outFilePath); err != nil {
+				fatal(err.Error())
+			}
+		}
+		if pipe != nil {
+			if err := pipe.wait(); err != nil {
+				fatalf("error: pipe-to %s: %s", *pipeTo, err)
+			}
+		}
+		inSize = inputSize(inFilePath)
+		if bar != nil {
+			bar.stop()
 		}
 
 	} else {
+		inHash := sha256.New()
 		// read from inFile into z
 		go func() {
 			defer pw.Close()
 			var z io.WriteCloser
-			var inFile *os.File
+			var inFile io.ReadCloser
 			var err error
-			if stdin == true {
+			if urlInput != "" {
+				inFile, err = openURL(urlInput)
+			} else if stdin == true {
 				inFile = os.Stdin
-				defer inFile.Close()
-				if *algorithm == "lzma" {
-					z = lzma.NewWriter(pw)
-				} else if *algorithm == "gzip" {
-					z = gzip.NewWriter(pw)
-				} else if *algorithm == "brotli" {
-					z = brotli.NewWriter(pw)
-				} else if *algorithm == "zlib" {
-					z = zlib.NewWriter(pw)
-				} else if *algorithm == "bzip2" {
-					z, _ = bzip2.NewWriter(pw, nil)
-				} else if *algorithm == "s2" {
-					z = s2.NewWriter(pw)
-				} else if *algorithm == "zstd" {
-					z, _ = zstd.NewWriter(pw)
-				} else if *algorithm == "xz" {
-					z, _ = xz.NewWriter(pw)
-				}
-				defer z.Close()
 			} else {
 				inFile, err = os.Open(inFilePath)
-				defer inFile.Close()
-				if err != nil {
-					log.Fatal(err.Error())
-				}
-				if *algorithm == "lzma" {
-					z = lzma.NewWriter(pw)
-				} else if *algorithm == "gzip" {
-					z = gzip.NewWriter(pw)
-				} else if *algorithm == "brotli" {
-					z = brotli.NewWriter(pw)
-				} else if *algorithm == "zlib" {
-					z = zlib.NewWriter(pw)
-				} else if *algorithm == "bzip2" {
-					z, _ = bzip2.NewWriter(pw, nil)
-				} else if *algorithm == "zstd" {
-					z, _ = zstd.NewWriter(pw)
-				} else if *algorithm == "xz" {
-					z, _ = xz.NewWriter(pw)
+			}
+			if err != nil {
+				fatal(err.Error())
+			}
+			defer inFile.Close()
+			var size int64
+			if f, ok := inFile.(*os.File); ok && *recompress == "" {
+				if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+					size = windowSize(fi.Size())
 				}
-				defer z.Close()
 			}
+			if (*embedMeta == true || *compat == true) && stdin == false {
+				inputMeta = &fileMeta{Name: originalName(inFilePath), MTime: inputModTime(inFilePath)}
+			}
+			z, err = newCompressor(pw, *algorithm, size)
+			if err != nil {
+				fatal(err.Error())
+			}
+			defer z.Close()
 
-			_, err = io.Copy(z, inFile)
+			var src io.Reader = inFile
+			if len(autoSample) > 0 {
+				src = io.MultiReader(bytes.NewReader(autoSample), src)
+			}
+			if *skipFlag != "" || *readFlag != "" {
+				if src, err = windowInput(src); err != nil {
+					fatal(err.Error())
+				}
+			}
+			if bar != nil {
+				src = bar.reader(src)
+			}
+			if *recompress != "" {
+				if memLimitSz > 0 {
+					if src, err = checkMemLimit(src, memLimitSz); err != nil {
+						fatal(err.Error())
+					}
+				}
+				_, zr, err := detectAlgorithm(src)
+				if err != nil {
+					fatal(err.Error())
+				}
+				defer zr.Close()
+				src = zr
+			}
+			if *verify == true {
+				src = io.TeeReader(src, inHash)
+			}
+			if plainCRC != nil {
+				src = io.TeeReader(src, plainCRC)
+			}
+			inSize, err = copyBuffer(z, src)
 			if err != nil {
-				log.Fatal(err.Error())
+				fatal(err.Error())
 			}
 		}()
 
 		// write into outFile from pr
 		defer pr.Close()
-		var outFile *os.File
+		var outFile io.WriteCloser
 		var err error
 		if *stdout == true {
 			outFile = os.Stdout
+		} else if *split != "" {
+			trackPartial(outFilePath)
+			outFile, err = createVolumes(outFilePath, splitSize)
+		} else if *appendOut == true {
+			trackPartial(outFilePath)
+			outFile, err = os.OpenFile(outFilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 		} else {
-			outFile, err = os.Create(outFilePath)
+			outFile, err = createOutput(outFilePath)
+			if isURL(outFilePath) == false {
+				trackPartial(outFilePath)
+			}
 		}
 		defer outFile.Close()
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
 		}
 
-		_, err = io.Copy(outFile, pr)
+		var dst io.Writer = outFile
+		if limiter != nil {
+			dst = &limitedWriter{w: outFile, l: limiter}
+		}
+		var outHash hash.Hash
+		if *checksum != "" {
+			outHash = newHash(*checksum)
+			dst = io.MultiWriter(dst, outHash)
+		}
+		var pipe *pipeCmd
+		if *pipeTo != "" {
+			if pipe, err = startPipe(*pipeTo); err != nil {
+				fatal(err.Error())
+			}
+			dst = io.MultiWriter(dst, pipe)
+		}
+		outSize, err = copyBuffer(dst, pr)
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
+		}
+		if u, ok := outFile.(*urlWriter); ok {
+			if err := u.Close(); err != nil {
+				fatal(err.Error())
+			}
+		}
+		if *syncOut == true && *stdout == false {
+			if err := syncOutput(outFile, outFilePath); err != nil {
+				fatal(err.Error())
+			}
+		}
+		if pipe != nil {
+			if err := pipe.wait(); err != nil {
+				fatalf("error: pipe-to %s: %s", *pipeTo, err)
+			}
+		}
+		if bar != nil {
+			bar.stop()
+		}
+
+		if *keepSmall == true {
+			fi, err := os.Stat(inFilePath)
+			if err != nil {
+				fatal(err.Error())
+			}
+			if outSize > fi.Size() {
+				removeOutput(outFilePath)
+				left := "left uncompressed"
+				if *recompress != "" {
+					left = "left as it was"
+				}
+				warnf("warning: %s would grow from %d to %d bytes, %s", inFilePath, fi.Size(), outSize, left)
+				printSummary(inFilePath, "skipped", fi.Size(), outSize)
+				return
+			}
+		}
+		if *verify == true {
+			err = verifyOutput(outFilePath, inHash.Sum(nil))
+			if err != nil {
+				removeOutput(outFilePath)
+				fatalf("error: verify %s: %s", outFilePath, err)
+			}
+		}
+		if outHash != nil {
+			err = writeSidecar(outFilePath, outHash.Sum(nil))
+			if err != nil {
+				fatal(err.Error())
+			}
 		}
 	}
 
-	if *stdout == false && *keep == false {
-		err := os.Remove(inFilePath)
+	keepOutput()
+	if meta != nil && *stdout == false {
+		if err := restoreModTime(outFilePath, meta); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if *stdout == false && (*chmod != "" || *chown != "") {
+		if err := setAttrs(outFilePath); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *stdout == false && *keep == false && stdin == false {
+		var err error
+		if *backupDir != "" {
+			err = backupInput(inFilePath)
+		} else {
+			err = removeInput(inFilePath)
+		}
 		if err != nil {
-			log.Fatal(err.Error())
+			fatal(err.Error())
+		}
+		if sidecar != "" && *backupDir != "" {
+			backupFile(sidecar)
+		} else if sidecar != "" {
+			os.Remove(sidecar)
 		}
 	}
+	printSummary(inFilePath, "ok", inSize, outSize)
 }
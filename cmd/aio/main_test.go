@@ -0,0 +1,316 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so that
+// the command can be tested end to end, exit status included.
+const runMainEnv = "AIO_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	// main sets these from the flags, which tests calling the helpers
+	// directly don't parse.
+	bufferSize = 256 << 10
+	os.Exit(m.Run())
+}
+
+// result is the outcome of a run of the command.
+type result struct {
+	stdout, stderr string
+	status         int
+}
+
+// runAio runs the command with args in dir, with env added to the
+// environment and stdin as its standard input.
+func runAio(t *testing.T, env []string, dir, stdin string, args ...string) result {
+	t.Helper()
+	return runAioFrom(t, env, dir, strings.NewReader(stdin), args...)
+}
+
+// runAioFrom is runAio reading standard input from stdin, which is passed
+// on as is when it is a file.
+func runAioFrom(t *testing.T, env []string, dir string, stdin io.Reader, args ...string) result {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "AIO_") == false {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(append(cmd.Env, runMainEnv+"=1"), env...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	status := 0
+	if e, ok := err.(*exec.ExitError); ok {
+		status = e.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return result{stdout.String(), stderr.String(), status}
+}
+
+// mustRun is runAio for runs that are expected to succeed.
+func mustRun(t *testing.T, dir string, args ...string) result {
+	t.Helper()
+	r := runAio(t, nil, dir, "", args...)
+	if r.status != 0 {
+		t.Fatalf("aio %s: exit status %d: %s", strings.Join(args, " "), r.status, r.stderr)
+	}
+	return r
+}
+
+func TestHelp(t *testing.T) {
+	r := runAio(t, nil, t.TempDir(), "", "-h")
+	if r.status != 0 || strings.Contains(r.stderr, "Usage:") == false {
+		t.Errorf("aio -h: exit status %d, stderr %q, want 0 and the usage", r.status, r.stderr)
+	}
+}
+
+// TestGzipMtimeReproducible compresses the same file twice, its time
+// changed in between, and expects the same bytes with -mtime 0.
+func TestGzipMtimeReproducible(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f")
+	if err := ioutil.WriteFile(p, []byte("reproducible\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var outputs []string
+	for _, mtime := range []time.Time{time.Unix(1500000000, 0), time.Unix(1600000000, 0)} {
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		r := mustRun(t, dir, "-c", "-a", "gzip", "-mtime", "0", "-comment", "c", "f")
+		outputs = append(outputs, r.stdout)
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("two runs with -mtime 0 differ: %x and %x", outputs[0], outputs[1])
+	}
+	if len(outputs[0]) < 8 || outputs[0][4:8] != "\x00\x00\x00\x00" {
+		t.Errorf("the gzip header %x has a modification time", outputs[0][:minInt(10, len(outputs[0]))])
+	}
+}
+
+func TestFreeName(t *testing.T) {
+	tests := []struct {
+		name string
+		// taken is the number of names that exist: f.gz, then f.1.gz, ...
+		taken int
+		want  string
+	}{
+		{"free", 0, "f.gz"},
+		{"first", 1, "f.1.gz"},
+		{"second", 2, "f.2.gz"},
+		{"tenth", 10, "f.10.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i := 0; i < tt.taken; i++ {
+				name := "f.gz"
+				if i > 0 {
+					name = fmt.Sprintf("f.%d.gz", i)
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := freeName(filepath.Join(dir, "f.gz")); got != filepath.Join(dir, tt.want) {
+				t.Errorf("freeName with %d taken = %s, want %s", tt.taken, filepath.Base(got), tt.want)
+			}
+		})
+	}
+}
+
+// TestStdinAmongFiles gives standard input as one of several inputs.
+func TestStdinAmongFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("from the file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := runAio(t, nil, dir, "from stdin\n", "-c", "-", "f")
+	if r.status != 0 {
+		t.Fatalf("aio -c - f: exit status %d: %s", r.status, r.stderr)
+	}
+	out, err := aio.Decompress("gzip", []byte(r.stdout))
+	if err != nil || string(out) != "from stdin\nfrom the file\n" {
+		t.Errorf("aio -c - f = %q, %v, want standard input then f", out, err)
+	}
+
+	for _, args := range [][]string{{"-", "-"}, {"-c", "-", "-"}, {"-", "f"}} {
+		r := runAio(t, nil, dir, "from stdin\n", args...)
+		if r.status == 0 {
+			t.Errorf("aio %s succeeded, want it refused", strings.Join(args, " "))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f")); err != nil {
+		t.Errorf("f was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f.gz")); os.IsNotExist(err) == false {
+		t.Errorf("f.gz was written")
+	}
+}
+
+// TestRecompressConcat recompresses inputs of two formats to one zstd
+// stream on standard output, which must hold their decompressed contents.
+func TestRecompressConcat(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []struct{ name, algo, data string }{
+		{"a.gz", "gzip", "first\n"},
+		{"b.bz2", "bzip2", "second\n"},
+	}
+	for _, in := range inputs {
+		data, err := aio.Compress(in.algo, aio.DefaultLevel, []byte(in.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, in.name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := mustRun(t, dir, "-recompress", "zstd", "-c", "a.gz", "b.bz2")
+	out, err := aio.Decompress("zstd", []byte(r.stdout))
+	if err != nil || string(out) != "first\nsecond\n" {
+		t.Errorf("aio -recompress zstd -c a.gz b.bz2 decodes to %q, %v, want the decompressed inputs", out, err)
+	}
+}
+
+// TestConcatWindow checks that -skip-bytes and -read-bytes, which apply
+// to a single input, are refused with -c and several files.
+func TestConcatWindow(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, opt := range []string{"-skip-bytes", "-read-bytes"} {
+		r := runAio(t, nil, dir, "", "-c", opt, "4", "a", "b")
+		if r.status == 0 || strings.Contains(r.stderr, "not to -c with several files") == false {
+			t.Errorf("aio -c %s 4 a b: exit status %d, stderr %q, want it refused", opt, r.status, r.stderr)
+		}
+	}
+	r := mustRun(t, dir, "-c", "-read-bytes", "4", "a")
+	if out, err := aio.Decompress("gzip", []byte(r.stdout)); err != nil || string(out) != "0123" {
+		t.Errorf("aio -c -read-bytes 4 a = %q, %v, want 0123", out, err)
+	}
+}
+
+// BenchmarkBufferS2 copies 16 MiB into the s2 compressor, and the stream
+// out of the decompressor, with the -buffer sizes given.
+func BenchmarkBufferS2(b *testing.B) {
+	in := sampleText(16 << 20)
+	var compressed bytes.Buffer
+	z, err := newCompressor(&compressed, "s2", 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	z.Write(in)
+	if err := z.Close(); err != nil {
+		b.Fatal(err)
+	}
+	defer func(n int) { bufferSize = n }(bufferSize)
+	for _, size := range []int{32 << 10, 256 << 10, 1 << 20} {
+		bufferSize = size
+		b.Run(fmt.Sprintf("compress/%dK", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				z, err := newCompressor(ioutil.Discard, "s2", 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := copyBuffer(z, bytes.NewReader(in)); err != nil {
+					b.Fatal(err)
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("decompress/%dK", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				r, err := newDecompressor(bytes.NewReader(compressed.Bytes()), "s2")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := copyBuffer(ioutil.Discard, r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestOutputIsInput refuses outputs that name the input file, however
+// they spell it, and leaves the input alone.
+func TestOutputIsInput(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"-o", "f", "f"},
+		{"-f", "-o", "./f", "f"},
+		{"-f", "-o", filepath.Join(dir, "f"), "f"},
+		{"-f", "-name-template", "{name}{ext}", "f"},
+		{"-f", "-O", dir, "-name-template", "{name}{ext}", "f"},
+		{"-f", "-d", "-o", "f", "f"},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := runAio(t, nil, dir, "", args...)
+		if r.status == 0 || strings.Contains(r.stderr, "is the input file") == false {
+			t.Errorf("aio %s: exit status %d, stderr %q, want it refused", strings.Join(args, " "), r.status, r.stderr)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "f")); err != nil || len(data) != 20 {
+			t.Errorf("aio %s: the input was changed: %v", strings.Join(args, " "), err)
+		}
+	}
+}
+
+// TestDryRunUnread runs -dry-run where a real run would read the input
+// to pick the algorithm, on contents no algorithm decodes, and expects a
+// report saying how it would be picked rather than an error.
+func TestDryRunUnread(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.dat"), []byte("not compressed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-dry-run", "-d", "f.dat"}, "would decompress f.dat to f.dat.out with the detected algorithm"},
+		{[]string{"-dry-run", "-d", "-embed-meta", "f.dat"}, "would name the output after the name stored in f.dat"},
+		{[]string{"-dry-run", "-a", "auto", "f.dat"}, "would compress f.dat to f.dat.{suffix} with " + autoDryRun},
+		{[]string{"-dry-run", "-skip-compressed", "f.dat"}, "would skip f.dat if a sample of it doesn't shrink"},
+	} {
+		r := mustRun(t, dir, tt.args...)
+		if strings.Contains(r.stdout, tt.want) == false {
+			t.Errorf("aio %s printed %q, want %q", strings.Join(tt.args, " "), r.stdout, tt.want)
+		}
+	}
+	if r := runAio(t, nil, dir, "", "-d", "-k", "f.dat"); r.status == 0 {
+		t.Errorf("aio -d -k f.dat succeeded on contents no algorithm decodes")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pedroalbanese/aio"
+)
+
+const (
+	// xzStreamHeader is the size of the xz stream header, which is
+	// followed by the header of the first block.
+	xzStreamHeader = 12
+	xzFilterLzma2  = 0x21
+)
+
+// checkMemLimit peeks at the header of r and fails when an xz or lzma
+// stream declares a dictionary larger than limit bytes. Neither decoder
+// can bound its allocation itself. For xz only the first block is
+// checked. The returned reader must be used in place of r.
+func checkMemLimit(r io.Reader, limit int64) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, err := br.Peek(xzStreamHeader + 1024)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var dict uint64
+	switch aio.Identify(h) {
+	case "lzma":
+		dict = uint64(binary.LittleEndian.Uint32(h[1:5]))
+	case "xz":
+		if len(h) <= xzStreamHeader {
+			return nil, fmt.Errorf("xz stream header truncated at %d bytes", len(h))
+		}
+		dict = xzDictSize(h[xzStreamHeader:])
+	}
+	if dict > uint64(limit) {
+		return nil, fmt.Errorf("stream requires %d MB, exceeds limit", (dict+1<<20-1)>>20)
+	}
+	return br, nil
+}
+
+// xzDictSize returns the LZMA2 dictionary size declared by the xz block
+// header h, or 0 when it can't be read.
+func xzDictSize(h []byte) uint64 {
+	if len(h) < 2 || h[0] == 0 || len(h) < (int(h[0])+1)*4 {
+		// A zero size byte starts the index of an empty stream.
+		return 0
+	}
+	flags := h[1]
+	p := h[2 : (int(h[0])+1)*4]
+	var fields []uint64
+	next := func() bool {
+		v, n := binary.Uvarint(p)
+		if n <= 0 {
+			return false
+		}
+		fields = append(fields, v)
+		p = p[n:]
+		return true
+	}
+	if flags&0x40 != 0 && next() == false || flags&0x80 != 0 && next() == false {
+		return 0
+	}
+	for i := 0; i <= int(flags&3); i++ {
+		fields = fields[:0]
+		if next() == false || next() == false || uint64(len(p)) < fields[1] {
+			return 0
+		}
+		props := p[:fields[1]]
+		p = p[fields[1]:]
+		if fields[0] == xzFilterLzma2 && len(props) == 1 {
+			bits := uint(props[0] & 0x3f)
+			if bits > 40 {
+				return 0
+			}
+			if bits == 40 {
+				return 1<<32 - 1
+			}
+			return uint64(2|bits&1) << (bits/2 + 11)
+		}
+	}
+	return 0
+}
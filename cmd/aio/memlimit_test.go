@@ -0,0 +1,75 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+func TestCheckMemLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("memory limit "), 1000)
+	xzData, err := aio.Compress("xz", aio.DefaultLevel, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lzmaData, err := aio.Compress("lzma", aio.DefaultLevel, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		input []byte
+		limit int64
+		want  string
+	}{
+		{"xz under", xzData, 1 << 30, ""},
+		{"xz over", xzData, 1 << 20, "exceeds limit"},
+		{"lzma under", lzmaData, 1 << 30, ""},
+		{"lzma over", lzmaData, 1 << 10, "exceeds limit"},
+		{"xz truncated", xzData[:8], 1 << 20, "truncated"},
+		{"xz stream header only", xzData[:xzStreamHeader], 1 << 20, "truncated"},
+		{"other format", []byte("plain text"), 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := checkMemLimit(bytes.NewReader(tt.input), tt.limit)
+			if tt.want != "" {
+				if err == nil || strings.Contains(err.Error(), tt.want) == false {
+					t.Fatalf("checkMemLimit = %v, want an error containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil || bytes.Equal(got, tt.input) == false {
+				t.Errorf("the returned reader doesn't yield the input: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemLimitTruncatedXz(t *testing.T) {
+	dir := t.TempDir()
+	// The xz magic and two bytes of stream flags.
+	if err := ioutil.WriteFile(filepath.Join(dir, "short.xz"), []byte("\xfd7zXZ\x00\x00\x04"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-d", "-c", "-mem-limit", "1M", "short.xz"},
+		{"-cat", "-mem-limit", "1M", "short.xz"},
+	} {
+		r := runAio(t, nil, dir, "", args...)
+		if r.status == 0 || strings.Contains(r.stderr, "panic") || strings.Contains(r.stderr, "truncated") == false {
+			t.Errorf("aio %s: exit status %d, stderr %q, want a truncated header error", strings.Join(args, " "), r.status, r.stderr)
+		}
+	}
+}
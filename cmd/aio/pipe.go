@@ -0,0 +1,60 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// pipeCmd is an external command fed a copy of the output by -pipe-to.
+type pipeCmd struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	err   error
+}
+
+// startPipe runs command through the shell with its standard input
+// connected to the returned pipeCmd. The command writes on standard
+// error when the output itself goes to standard output.
+func startPipe(command string) (*pipeCmd, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = os.Stdout
+	if *stdout == true {
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pipeCmd{cmd: cmd, stdin: stdin}, nil
+}
+
+// Write passes b to the command. A command may stop reading before the
+// end, like head, so a failed write only stops feeding it and doesn't
+// interrupt the output.
+func (p *pipeCmd) Write(b []byte) (int, error) {
+	if p.err == nil {
+		_, p.err = p.stdin.Write(b)
+	}
+	return len(b), nil
+}
+
+// wait closes the standard input of the command and returns its exit
+// status as an error.
+func (p *pipeCmd) wait() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
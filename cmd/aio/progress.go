@@ -0,0 +1,119 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progressBar renders the amount of input read on standard error: a bar
+// with percent and ETA when the total is known, a spinner with the rate
+// otherwise.
+type progressBar struct {
+	n     int64 // updated atomically by the copying goroutine
+	total int64
+	start time.Time
+	done  chan struct{}
+	exit  chan struct{}
+}
+
+// isTerminal reports whether f is a character device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress starts the rendering goroutine. total is 0 when unknown.
+func startProgress(total int64) *progressBar {
+	p := &progressBar{total: total, start: time.Now(), done: make(chan struct{}), exit: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+func (p *progressBar) run() {
+	defer close(p.exit)
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-p.done:
+			p.render(i)
+			fmt.Fprintln(os.Stderr)
+			return
+		case <-t.C:
+			p.render(i)
+		}
+	}
+}
+
+func (p *progressBar) render(tick int) {
+	n := atomic.LoadInt64(&p.n)
+	elapsed := time.Since(p.start)
+	if p.total <= 0 {
+		rate := float64(n) / elapsed.Seconds()
+		fmt.Fprintf(os.Stderr, "\r%c %s %s/s   ", `|/-\`[tick%4], formatBytes(n), formatBytes(int64(rate)))
+		return
+	}
+	const width = 30
+	frac := float64(n) / float64(p.total)
+	if frac > 1 {
+		frac = 1
+	}
+	eta := "--:--"
+	if n > 0 {
+		left := time.Duration(float64(elapsed) / frac * (1 - frac))
+		eta = fmt.Sprintf("%02d:%02d", int(left.Minutes()), int(left.Seconds())%60)
+	}
+	full := int(frac * width)
+	bar := make([]byte, width)
+	for i := range bar {
+		if i < full {
+			bar[i] = '='
+		} else {
+			bar[i] = ' '
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s] %3d%% %s / %s ETA %s   ", bar, int(frac*100), formatBytes(n), formatBytes(p.total), eta)
+}
+
+// stop renders the final state and waits for the goroutine to finish.
+func (p *progressBar) stop() {
+	close(p.done)
+	<-p.exit
+}
+
+func (p *progressBar) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progressBar
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(&r.p.n, int64(n))
+	return n, err
+}
+
+// formatBytes formats n with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding at most one second of tokens.
+// Every stream wrapped with the same limiter draws from it, so their
+// combined throughput stays under the rate.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSecond), last: time.Now()}
+}
+
+// wait takes n tokens, sleeping until the bucket is out of debt. Holding
+// the lock while sleeping makes the other streams wait their turn.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	w.l.wait(len(p))
+	return w.w.Write(p)
+}
+
+// limitedFile reads through a wrapper of a file and closes the file.
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// urlInput is the http or https URL read instead of standard input, or
+// "" for none.
+var urlInput string
+
+// isURL reports whether p names a remote file rather than a local one.
+func isURL(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// openURL starts a GET of u and returns the response body.
+func openURL(u string) (io.ReadCloser, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// urlWriter streams what is written to it as the body of an upload to a
+// URL with the -upload-method. The upload is only complete once Close
+// returns without error.
+type urlWriter struct {
+	url  string
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+	// closed is set once Close has waited for the upload.
+	closed bool
+}
+
+func createURL(u string) (*urlWriter, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(*uploadVia, u, pr)
+	if err != nil {
+		return nil, err
+	}
+	w := &urlWriter{url: u, pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("%s %s: %s", *uploadVia, u, resp.Status)
+			}
+		}
+		// A server failing early stops the writes instead of blocking
+		// them.
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (w *urlWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the body and waits for the response. It may be called
+// again, returning the same result.
+func (w *urlWriter) Close() error {
+	if w.closed == false {
+		w.pw.Close()
+		w.err = <-w.done
+		w.closed = true
+	}
+	return w.err
+}
+
+// createOutput creates the output file p, or starts its upload when p is
+// a URL.
+func createOutput(p string) (io.WriteCloser, error) {
+	if isURL(p) {
+		return createURL(p)
+	}
+	return os.Create(p)
+}
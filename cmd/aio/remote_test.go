@@ -0,0 +1,48 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestURLInputSize checks that the summary of a URL input doesn't report
+// the size of standard input, here a file, which isn't read.
+func TestURLInputSize(t *testing.T) {
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	z.Write([]byte("hello"))
+	z.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "stdin")
+	if err := ioutil.WriteFile(p, bytes.Repeat([]byte("x"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := runAioFrom(t, nil, dir, f, "-d", "-c", "-summary", srv.URL+"/file.gz")
+	if r.status != 0 || r.stdout != "hello" {
+		t.Fatalf("aio -d -c URL: exit status %d, stdout %q: %s", r.status, r.stdout, r.stderr)
+	}
+	want := "ok\t" + srv.URL + "/file.gz\t0\t5\t"
+	if strings.HasPrefix(r.stderr, want) == false {
+		t.Errorf("summary = %q, want it to start with %q", r.stderr, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"compress/gzip"
+)
+
+// rsyncWindow is the size of the rolling window, as in GNU gzip.
+const rsyncWindow = 4096
+
+// rsyncMinBlock is the fewest input bytes between two flushes.
+const rsyncMinBlock = 256
+
+// rsyncWriter flushes the gzip stream whenever a rolling sum over the last
+// rsyncWindow input bytes hits a boundary. The boundaries depend on the
+// window alone, so after a change in the input they fall at the same
+// places again within a window, and the compressed output only differs up
+// to there, which keeps the rest of the file rsync and dedup friendly.
+type rsyncWriter struct {
+	z      *gzip.Writer
+	window [rsyncWindow]byte
+	pos    int
+	sum    uint32
+	since  int
+}
+
+func newRsyncWriter(z *gzip.Writer) *rsyncWriter {
+	return &rsyncWriter{z: z}
+}
+
+func (w *rsyncWriter) Write(p []byte) (int, error) {
+	start := 0
+	for i, b := range p {
+		w.sum += uint32(b) - uint32(w.window[w.pos])
+		w.window[w.pos] = b
+		w.pos = (w.pos + 1) % rsyncWindow
+		w.since++
+		// Runs of a single byte value keep the sum on a boundary, so
+		// the blocks have a minimum size; it is well below the mean
+		// distance between boundaries, so that skipping one seldom
+		// shifts the next.
+		if w.sum%rsyncWindow != 0 || w.since < rsyncMinBlock {
+			continue
+		}
+		if _, err := w.z.Write(p[start : i+1]); err != nil {
+			return start, err
+		}
+		if err := w.z.Flush(); err != nil {
+			return i + 1, err
+		}
+		start = i + 1
+		w.since = 0
+	}
+	n, err := w.z.Write(p[start:])
+	return start + n, err
+}
+
+func (w *rsyncWriter) Close() error {
+	return w.z.Close()
+}
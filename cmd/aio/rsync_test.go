@@ -0,0 +1,101 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sampleText returns n bytes of compressible text that doesn't repeat.
+func sampleText(n int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	words := strings.Fields("alpha beta gamma delta epsilon zeta eta theta iota kappa lambda mu")
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		fmt.Fprintf(&buf, "%s %d ", words[rnd.Intn(len(words))], rnd.Intn(1000))
+	}
+	return buf.Bytes()[:n]
+}
+
+// rsyncGzip compresses in with an rsyncWriter.
+func rsyncGzip(t *testing.T, in []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newRsyncWriter(gzip.NewWriter(&buf))
+	if _, err := w.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRsyncable(t *testing.T) {
+	in := sampleText(4 << 20)
+	changed := append([]byte(nil), in...)
+	changed[1000] ^= 1
+	a, b := rsyncGzip(t, in), rsyncGzip(t, changed)
+
+	out, err := ioutil.ReadAll(mustGunzip(t, b))
+	if err != nil || bytes.Equal(out, changed) == false {
+		t.Fatalf("the rsyncable stream doesn't decode to its input: %v", err)
+	}
+	// The 8 byte trailer holds the CRC, which differs; count the common
+	// bytes before it from the end.
+	a, b = a[:len(a)-8], b[:len(b)-8]
+	same := 0
+	for same < len(a) && same < len(b) && a[len(a)-1-same] == b[len(b)-1-same] {
+		same++
+	}
+	if same < len(a)*9/10 {
+		t.Errorf("%d of %d compressed bytes are the same after a change at byte 1000, want most of them", same, len(a))
+	}
+}
+
+// TestRsyncResync inserts bytes near the start of the input, which
+// shifts everything after them, and expects the flushes to fall at the
+// same places in the rest of it again.
+func TestRsyncResync(t *testing.T) {
+	in := sampleText(4 << 20)
+	for _, n := range []int{1, 100, 3000} {
+		inserted := append(append(append([]byte(nil), in[:1000]...), bytes.Repeat([]byte("+"), n)...), in[1000:]...)
+		a, b := rsyncGzip(t, in), rsyncGzip(t, inserted)
+		a, b = a[:len(a)-8], b[:len(b)-8]
+		same := 0
+		for same < len(a) && same < len(b) && a[len(a)-1-same] == b[len(b)-1-same] {
+			same++
+		}
+		if same < len(a)*9/10 {
+			t.Errorf("%d of %d compressed bytes are the same after inserting %d bytes at byte 1000, want most of them", same, len(a), n)
+		}
+	}
+}
+
+func mustGunzip(t *testing.T, data []byte) *gzip.Reader {
+	t.Helper()
+	z, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func TestRsyncableOtherAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := runAio(t, nil, dir, "", "-rsyncable", "-a", "zstd", "f")
+	if r.status == 0 || strings.Contains(r.stderr, "rsyncable is only supported by gzip") == false {
+		t.Errorf("aio -rsyncable -a zstd: exit status %d, stderr %q, want it refused", r.status, r.stderr)
+	}
+}
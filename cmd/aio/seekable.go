@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// seekableFrameSize is the amount of input compressed into each
+	// independent frame of a seekable zstd stream.
+	seekableFrameSize = 1 << 20
+
+	seekTableMagic = 0x184d2a5e
+	seekableMagic  = 0x8f92eab1
+)
+
+// seekableWriter writes the zstd seekable format: the input is cut into
+// independent frames, followed by a skippable frame holding a seek table
+// of the compressed and decompressed size of each frame. Regular zstd
+// decoders skip the table and decode the frames as one stream.
+type seekableWriter struct {
+	w     io.Writer
+	enc   *zstd.Encoder
+	buf   []byte
+	table []byte
+	n     uint32
+}
+
+func newSeekableWriter(w io.Writer) (*seekableWriter, error) {
+	enc, err := zstd.NewWriter(nil, zstdEncoderOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &seekableWriter{w: w, enc: enc, buf: make([]byte, 0, seekableFrameSize)}, nil
+}
+
+func (s *seekableWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		c := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		n += c
+		p = p[c:]
+		if len(s.buf) == cap(s.buf) {
+			if err = s.flushFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *seekableWriter) flushFrame() error {
+	frame := s.enc.EncodeAll(s.buf, nil)
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	var entry [8]byte
+	binary.LittleEndian.PutUint32(entry[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(entry[4:], uint32(len(s.buf)))
+	s.table = append(s.table, entry[:]...)
+	s.n++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close writes the last frame and the seek table.
+func (s *seekableWriter) Close() error {
+	defer s.enc.Close()
+	if len(s.buf) > 0 || s.n == 0 {
+		if err := s.flushFrame(); err != nil {
+			return err
+		}
+	}
+	var footer [9]byte
+	binary.LittleEndian.PutUint32(footer[0:], s.n)
+	// footer[4] is the descriptor; no per-frame checksums are stored.
+	binary.LittleEndian.PutUint32(footer[5:], seekableMagic)
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], seekTableMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(s.table)+len(footer)))
+	for _, b := range [][]byte{header[:], s.table, footer[:]} {
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
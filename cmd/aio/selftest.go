@@ -0,0 +1,102 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pedroalbanese/aio"
+)
+
+// selfTestSample is a named input of the self test.
+type selfTestSample struct {
+	name string
+	data []byte
+}
+
+// selfTestSamples returns inputs covering the cases the codecs handle
+// differently: nothing, text, incompressible data, a long run and data
+// larger than the s2 and snappy blocks.
+func selfTestSamples() []selfTestSample {
+	var text bytes.Buffer
+	for i := 0; text.Len() < 100<<10; i++ {
+		fmt.Fprintf(&text, "line %d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+	random := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	large := bytes.Repeat(append(random[:1000:1000], text.Bytes()[:3000]...), 300)
+	return []selfTestSample{
+		{"empty", nil},
+		{"text", text.Bytes()},
+		{"random", random},
+		{"zeros", make([]byte, 256<<10)},
+		{"large", large},
+	}
+}
+
+// selfTest compresses and decompresses the samples in memory with every
+// algorithm at its default, lowest and highest level, and prints whether
+// each round trip gives back the sample. Streams are also decoded with
+// the detected algorithm, except brotli, which can't be detected. It
+// reports whether any round trip failed.
+func selfTest() (failed bool) {
+	samples := selfTestSamples()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "algorithm\tlevel")
+	for _, s := range samples {
+		fmt.Fprintf(tw, "\t%s", s.name)
+	}
+	fmt.Fprintln(tw)
+	for _, name := range codecNames() {
+		c := codecs[name]
+		levels := []int{aio.DefaultLevel}
+		if c.MaxLevel != 0 {
+			levels = append(levels, c.MinLevel, c.MaxLevel)
+		}
+		for _, level := range levels {
+			label := "default"
+			if level != aio.DefaultLevel {
+				label = strconv.Itoa(level)
+			}
+			fmt.Fprintf(tw, "%s\t%s", name, label)
+			for _, s := range samples {
+				result := "ok"
+				if err := roundTrip(name, level, s.data); err != nil {
+					result = "FAIL: " + err.Error()
+					failed = true
+				}
+				fmt.Fprintf(tw, "\t%s", result)
+			}
+			fmt.Fprintln(tw)
+		}
+	}
+	tw.Flush()
+	return failed
+}
+
+func roundTrip(algo string, level int, data []byte) error {
+	z, err := aio.Compress(algo, level, data)
+	if err != nil {
+		return err
+	}
+	detect := []string{algo}
+	if algo != "brotli" {
+		detect = append(detect, "")
+	}
+	for _, a := range detect {
+		out, err := aio.Decompress(a, z)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(out, data) == false {
+			return fmt.Errorf("output differs")
+		}
+	}
+	return nil
+}
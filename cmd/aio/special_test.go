@@ -0,0 +1,116 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+func TestFIFO(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	// Without -c there is nowhere to write to, and the pipe mustn't go.
+	r := runAio(t, nil, dir, "", "fifo")
+	if r.status == 0 || strings.Contains(r.stderr, "named pipe") == false {
+		t.Errorf("aio fifo: exit status %d, stderr %q, want the named pipe refused", r.status, r.stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fifo.gz")); os.IsNotExist(err) == false {
+		t.Errorf("aio fifo created fifo.gz")
+	}
+
+	// With -c it is read as a stream, and kept.
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err == nil {
+			_, err = f.WriteString("through a pipe\n")
+			f.Close()
+		}
+		done <- err
+	}()
+	r = mustRun(t, dir, "-c", "fifo")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		// Unblock the writer if aio never opened the pipe.
+		if f, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+		<-done
+	}
+	if out, err := aio.Decompress("gzip", []byte(r.stdout)); err != nil || string(out) != "through a pipe\n" {
+		t.Errorf("aio -c fifo = %q, %v, want the data written to the pipe", out, err)
+	}
+	if fi, err := os.Lstat(fifo); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("the named pipe was removed or replaced: %v", err)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "target"), []byte("linked to\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"target", "d"} {
+		if err := os.Symlink(name, filepath.Join(dir, name+"-link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// Links are skipped by default, whatever they point to.
+	for _, link := range []string{"target-link", "d-link"} {
+		r := mustRun(t, dir, link)
+		if strings.Contains(r.stderr, "symbolic link") == false || exists(link+".gz") || exists(link) == false {
+			t.Errorf("aio %s: stderr %q, want the link skipped and kept", link, r.stderr)
+		}
+	}
+
+	// -c reads the target, which nothing removes.
+	r := mustRun(t, dir, "-c", "target-link")
+	if out, err := aio.Decompress("gzip", []byte(r.stdout)); err != nil || string(out) != "linked to\n" {
+		t.Errorf("aio -c target-link = %q, %v, want the target", out, err)
+	}
+
+	// -dereference compresses the target under the name of the link,
+	// and removes the link only.
+	mustRun(t, dir, "-dereference", "target-link")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "target-link.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := aio.Decompress("gzip", data); err != nil || string(out) != "linked to\n" {
+		t.Errorf("target-link.gz = %q, %v, want the target", out, err)
+	}
+	if exists("target-link") || exists("target") == false {
+		t.Errorf("-dereference left the link or removed the target")
+	}
+	if r := runAio(t, nil, dir, "", "-dereference", "d-link"); r.status == 0 || exists("d-link.gz") {
+		t.Errorf("aio -dereference d-link: exit status %d, want a link to a directory refused", r.status)
+	}
+}
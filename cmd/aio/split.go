@@ -0,0 +1,188 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// firstVolume is the suffix of the first volume of a split set.
+const firstVolume = ".001"
+
+// volumeWriter writes a stream to base.001, base.002, ... starting a new
+// volume every size bytes. The stream is split as raw bytes, so the
+// volumes must be joined back in order before decompressing.
+type volumeWriter struct {
+	base string
+	size int64
+	left int64
+	n    int
+	f    *os.File
+}
+
+func createVolumes(base string, size int64) (*volumeWriter, error) {
+	v := &volumeWriter{base: base, size: size}
+	return v, v.next()
+}
+
+func (v *volumeWriter) next() error {
+	if v.f != nil {
+		if *syncOut == true {
+			if err := v.f.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := v.f.Close(); err != nil {
+			return err
+		}
+	}
+	v.n++
+	f, err := os.Create(volumePath(v.base, v.n))
+	if err != nil {
+		return err
+	}
+	v.f, v.left = f, v.size
+	return nil
+}
+
+func (v *volumeWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if v.left == 0 {
+			if err = v.next(); err != nil {
+				return n, err
+			}
+		}
+		c := p
+		if int64(len(c)) > v.left {
+			c = c[:v.left]
+		}
+		m, err := v.f.Write(c)
+		n += m
+		v.left -= int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Sync flushes the current volume, the previous ones are flushed as
+// they are completed with -sync.
+func (v *volumeWriter) Sync() error {
+	return v.f.Sync()
+}
+
+func (v *volumeWriter) Close() error {
+	return v.f.Close()
+}
+
+// volumeReader reads a split set as one stream.
+type volumeReader struct {
+	paths []string
+	f     *os.File
+}
+
+func (v *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if v.f == nil {
+			if len(v.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(v.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			v.f, v.paths = f, v.paths[1:]
+		}
+		n, err := v.f.Read(p)
+		if err == io.EOF {
+			v.f.Close()
+			v.f = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (v *volumeReader) Close() error {
+	if v.f != nil {
+		return v.f.Close()
+	}
+	return nil
+}
+
+func volumePath(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// volumeBase returns the name of the split set p is the first volume
+// of, or p itself when it isn't one.
+func volumeBase(p string) string {
+	return strings.TrimSuffix(p, firstVolume)
+}
+
+// joinsVolumes reports whether the input p is read as the split set it
+// is the first volume of. Volumes are only joined to be decoded; a file
+// named *.001 that is being compressed is an ordinary file.
+func joinsVolumes(p string) bool {
+	return volumeBase(p) != p && (decoding() == true || *recompress != "")
+}
+
+// volumes returns the consecutive volumes of base that exist.
+func volumes(base string) (list []string) {
+	for n := 1; ; n++ {
+		p := volumePath(base, n)
+		if _, err := os.Lstat(p); err != nil {
+			return list
+		}
+		list = append(list, p)
+	}
+}
+
+// openInput opens p for reading, or downloads it when p is a URL. When p
+// is the first volume of a split set being decoded, the returned reader
+// joins all of its volumes in order.
+func openInput(p string) (io.ReadCloser, error) {
+	if isURL(p) {
+		return openURL(p)
+	}
+	if joinsVolumes(p) == false {
+		return os.Open(p)
+	}
+	return openVolumes(volumeBase(p))
+}
+
+// openVolumes returns a reader that joins the volumes of base in order.
+func openVolumes(base string) (io.ReadCloser, error) {
+	list := volumes(base)
+	if len(list) == 0 {
+		return nil, fmt.Errorf("open %s: %w", volumePath(base, 1), os.ErrNotExist)
+	}
+	return &volumeReader{paths: list}, nil
+}
+
+// removeInput removes p, or every volume of the set p is the first
+// volume of when it was decoded.
+func removeInput(p string) error {
+	if joinsVolumes(p) == false {
+		return os.Remove(p)
+	}
+	return removeVolumes(volumeBase(p))
+}
+
+func removeVolumes(base string) error {
+	for _, p := range volumes(base) {
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
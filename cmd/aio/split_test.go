@@ -0,0 +1,58 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(64 << 10)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, dir, "-split", "4K", "-verify", "f")
+	if _, err := os.Stat(filepath.Join(dir, "f.gz.002")); err != nil {
+		t.Fatalf("-split 4K wrote a single volume: %v", err)
+	}
+	mustRun(t, dir, "-d", "f.gz.001")
+	out, err := ioutil.ReadFile(filepath.Join(dir, "f"))
+	if err != nil || string(out) != string(in) {
+		t.Fatalf("the joined volumes don't decode to the input: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f.gz.002")); os.IsNotExist(err) == false {
+		t.Errorf("-d left the second volume behind: %v", err)
+	}
+}
+
+// TestCompressVolumeName compresses a file named like the first volume
+// of a split set, next to files named like the other volumes, and
+// expects only that file to be read and removed.
+func TestCompressVolumeName(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"data.001": "first\n", "data.002": "second\n", "data.003": "third\n"}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, dir, "data.001")
+	r := mustRun(t, dir, "-d", "-c", "data.001.gz")
+	if r.stdout != files["data.001"] {
+		t.Errorf("data.001.gz decodes to %q, want %q", r.stdout, files["data.001"])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data.001")); os.IsNotExist(err) == false {
+		t.Errorf("data.001 was kept after compressing it: %v", err)
+	}
+	for _, name := range []string{"data.002", "data.003"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != files[name] {
+			t.Errorf("%s was touched by compressing data.001: %q, %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+const suffixInput = "decoded by suffix\n"
+
+// writeCompressed writes suffixInput compressed with algo to name in dir.
+func writeCompressed(t *testing.T, dir, name, algo string) {
+	t.Helper()
+	data, err := aio.Compress(algo, aio.DefaultLevel, []byte(suffixInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressBySuffix(t *testing.T) {
+	tests := []struct {
+		name, file, algo string
+		args             []string
+		// out is the decompressed file, or "" for standard output.
+		out string
+	}{
+		{"brotli", "f.br", "brotli", []string{"-d", "-k", "f.br"}, "f"},
+		{"brotli stdout", "f.br", "brotli", []string{"-d", "-c", "f.br"}, ""},
+		{"brotli cat", "f.br", "brotli", []string{"-cat", "f.br"}, ""},
+		{"map-ext brotli", "x.foo", "brotli", []string{"-d", "-k", "-map-ext", ".foo=brotli", "x.foo"}, "x"},
+		{"map-ext stdout", "x.foo", "brotli", []string{"-d", "-c", "-map-ext", ".foo=brotli", "x.foo"}, ""},
+		{"map-ext gzip", "x.dat", "gzip", []string{"-d", "-k", "-map-ext", ".dat=gzip", "x.dat"}, "x"},
+		{"detected despite suffix", "f.gz", "zstd", []string{"-d", "-c", "f.gz"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeCompressed(t, dir, tt.file, tt.algo)
+			r := mustRun(t, dir, tt.args...)
+			got := r.stdout
+			if tt.out != "" {
+				data, err := ioutil.ReadFile(filepath.Join(dir, tt.out))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(data)
+			}
+			if got != suffixInput {
+				t.Errorf("aio %s decoded %q, want %q", strings.Join(tt.args, " "), got, suffixInput)
+			}
+		})
+	}
+}
+
+func TestDecompressBySuffixCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.br"), []byte("not brotli at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := runAio(t, nil, dir, "", "-d", "-k", "f.br")
+	if r.status == 0 {
+		t.Errorf("aio -d f.br decoded garbage")
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "f.br")); err != nil {
+		t.Errorf("the input was removed: %v", err)
+	}
+}
+
+// TestForceAlgorithm decodes with -a files whose suffix names no
+// algorithm, or another one.
+func TestForceAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	writeCompressed(t, dir, "f.dat", "gzip")
+	writeCompressed(t, dir, "g.gz", "brotli")
+	for _, args := range [][]string{
+		{"-d", "-k", "-a", "gzip", "-o", "out", "f.dat"},
+		{"-d", "-k", "-f", "-a", "brotli", "-o", "out", "g.gz"},
+	} {
+		mustRun(t, dir, args...)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+		if err != nil || string(data) != suffixInput {
+			t.Errorf("aio %s: out = %q, %v, want %q", strings.Join(args, " "), data, err, suffixInput)
+		}
+	}
+	if r := runAio(t, nil, dir, "", "-d", "-k", "-f", "-o", "out", "g.gz"); r.status == 0 {
+		t.Errorf("aio -d g.gz decoded a brotli stream as gzip")
+	}
+}
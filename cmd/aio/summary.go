@@ -0,0 +1,196 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fileResult is the outcome of processing a file, printed by -summary, or
+// by -log-format json for log aggregation. Ratio is the compressed size
+// over the uncompressed size, nil when either is empty. CRC32 is set with
+// -show-crc. Level, the -levels entry or the -level-name, is only set when
+// compressing, as is Auto when -a auto picked the algorithm, and Duration
+// counts from the start of the run.
+type fileResult struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Algorithm string   `json:"algorithm"`
+	Auto      bool     `json:"auto,omitempty"`
+	Level     string   `json:"level,omitempty"`
+	InSize    int64    `json:"in_bytes"`
+	OutSize   int64    `json:"out_bytes"`
+	Ratio     *float64 `json:"ratio"`
+	CRC32     string   `json:"crc32,omitempty"`
+	Duration  int64    `json:"duration_ms"`
+}
+
+// started is when the run started, for the duration of -summary.
+var started = time.Now()
+
+// results are the outcomes printSummary reported, totalled by
+// printTotals.
+var (
+	resultsMu sync.Mutex
+	results   []fileResult
+)
+
+// summaryTotals is the final record of -summary: the number of files and
+// their summed sizes.
+type summaryTotals struct {
+	Files   int      `json:"files"`
+	InSize  int64    `json:"in_bytes"`
+	OutSize int64    `json:"out_bytes"`
+	Ratio   *float64 `json:"ratio"`
+}
+
+// printSummary writes the result of processing the input of the run on
+// standard error with -summary, so that it doesn't mix with data on
+// standard output.
+func printSummary(file, status string, in, out int64) {
+	if urlInput != "" {
+		file = urlInput
+	} else if stdin == true {
+		file = "-"
+	}
+	r := fileResult{Name: file, Status: status, Algorithm: *algorithm, InSize: in, OutSize: out}
+	if decoding() == false {
+		r.Level = resultLevel(*algorithm)
+		r.Auto = autoPicked
+	}
+	if plainCRC != nil && status == "ok" {
+		r.CRC32 = fmt.Sprintf("%08x", plainCRC.Sum32())
+	}
+	printResult(r)
+}
+
+// printResult writes r with -summary. The modes that take several FILEs
+// call it for each of them.
+func printResult(r fileResult) {
+	if *summary == false {
+		return
+	}
+	r.Duration = time.Since(started).Milliseconds()
+	var ratio string
+	r.Ratio, ratio = sizeRatio(r.InSize, r.OutSize)
+	algo := r.Algorithm
+	if r.Auto == true {
+		algo += "\tauto"
+	}
+	crc := ""
+	if r.CRC32 != "" {
+		crc = "\tcrc=" + r.CRC32
+	}
+	resultsMu.Lock()
+	results = append(results, r)
+	resultsMu.Unlock()
+	if *summaryFmt == "json" {
+		json.NewEncoder(os.Stderr).Encode(r)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s\t%s\t%d\t%d\t%s\t%s%s\n", r.Status, r.Name, r.InSize, r.OutSize, ratio, algo, crc)
+}
+
+// currentInput is the input being processed, which fatal reports as
+// failed.
+var currentInput string
+
+// failOnce makes the first fatal error the one reported.
+var failOnce sync.Once
+
+// fatal reports currentInput as failed and prints the totals with
+// -summary, which the deferred printTotals would skip, then exits like
+// log.Fatal.
+func fatal(v ...interface{}) {
+	reportFailure()
+	log.Fatal(v...)
+}
+
+// fatalf is fatal with a format, like log.Fatalf.
+func fatalf(format string, v ...interface{}) {
+	reportFailure()
+	log.Fatalf(format, v...)
+}
+
+func reportFailure() {
+	failOnce.Do(func() {
+		if currentInput != "" {
+			printSummary(currentInput, "failed", inputSize(currentInput), 0)
+		}
+		printTotals()
+	})
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// exitStatus prints the totals of -summary and exits with status, for
+// the modes that end with a status of their own.
+func exitStatus(status int) {
+	printTotals()
+	os.Exit(status)
+}
+
+// printTotals writes the totals line of -summary after the lines of the
+// files, if any.
+func printTotals() {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	if *summary == false || len(results) == 0 {
+		return
+	}
+	t := summaryTotals{Files: len(results)}
+	for _, r := range results {
+		t.InSize += r.InSize
+		t.OutSize += r.OutSize
+	}
+	var ratio string
+	t.Ratio, ratio = sizeRatio(t.InSize, t.OutSize)
+	if *summaryFmt == "json" {
+		json.NewEncoder(os.Stderr).Encode(t)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "total\t%d\t%d\t%d\t%s\n", t.Files, t.InSize, t.OutSize, ratio)
+}
+
+// sizeRatio returns the compressed size over the uncompressed size of in
+// and out, and as text, or nil and "-" when either is empty.
+func sizeRatio(in, out int64) (*float64, string) {
+	compressed, plain := out, in
+	if decoding() == true {
+		compressed, plain = in, out
+	}
+	if plain == 0 || compressed == 0 {
+		return nil, "-"
+	}
+	v := float64(compressed) / float64(plain)
+	return &v, fmt.Sprintf("%.3f", v)
+}
+
+// resultLevel returns the level algo compressed with.
+func resultLevel(algo string) string {
+	if n, ok := levelMap[algo]; ok {
+		return strconv.Itoa(n)
+	}
+	if *store == true {
+		return "store"
+	}
+	return *levelName
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummaryTotals(t *testing.T) {
+	dir := t.TempDir()
+	in := strings.Repeat("summed up\n", 100)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := mustRun(t, dir, "-k", "-summary", "f")
+	fi, err := os.Stat(filepath.Join(dir, "f.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(r.stderr, "\n"), "\n")
+	ratio := fmt.Sprintf("%.3f", float64(fi.Size())/float64(len(in)))
+	want := fmt.Sprintf("total\t1\t%d\t%d\t%s", len(in), fi.Size(), ratio)
+	if len(lines) != 2 || lines[1] != want {
+		t.Errorf("summary = %q, want a file line then %q", r.stderr, want)
+	}
+
+	r = mustRun(t, dir, "-k", "-f", "-summary", "-summary-format", "json", "f")
+	lines = strings.Split(strings.TrimSuffix(r.stderr, "\n"), "\n")
+	var totals summaryTotals
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &totals) != nil {
+		t.Fatalf("json summary = %q, want a file object then the totals", r.stderr)
+	}
+	if totals.Files != 1 || totals.InSize != int64(len(in)) || totals.OutSize != fi.Size() || totals.Ratio == nil {
+		t.Errorf("json totals = %+v, want 1 file of %d bytes compressed to %d", totals, len(in), fi.Size())
+	}
+
+	// A skipped file counts too, and a run without a file prints nothing.
+	r = mustRun(t, dir, "-k", "-summary", "-skip-compressed", "f.gz")
+	if strings.HasSuffix(r.stderr, fmt.Sprintf("total\t1\t%d\t0\t-\n", fi.Size())) == false {
+		t.Errorf("summary of a skipped file = %q, want its totals", r.stderr)
+	}
+	if r = mustRun(t, dir, "-summary", "-capabilities"); strings.Contains(r.stderr, "total") {
+		t.Errorf("summary without a file = %q, want no totals", r.stderr)
+	}
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	dir := t.TempDir()
+	in := strings.Repeat("logged\n", 100)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := mustRun(t, dir, "-k", "-a", "zstd", "-log-format", "json", "f")
+	line := strings.SplitN(r.stderr, "\n", 2)[0]
+	var rec map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatalf("log line %q: %v", line, err)
+	}
+	for _, k := range []string{"name", "algorithm", "level", "in_bytes", "out_bytes", "ratio", "duration_ms", "status"} {
+		if _, ok := rec[k]; ok == false {
+			t.Errorf("log record %s has no %s", line, k)
+		}
+	}
+	if rec["name"] != "f" || rec["algorithm"] != "zstd" || rec["in_bytes"] != float64(len(in)) || rec["status"] != "ok" {
+		t.Errorf("log record = %s, want f compressed with zstd from %d bytes", line, len(in))
+	}
+
+	if r := runAio(t, nil, dir, "", "-k", "-f", "-log-format", "json", "-summary-format", "text", "f"); r.status == 0 {
+		t.Errorf("-log-format json with -summary-format text succeeded")
+	}
+}
+
+// TestSummaryAuto checks that the summary reports the algorithm -a auto
+// picked, marked as such.
+func TestSummaryAuto(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("tiny\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := mustRun(t, dir, "-k", "-a", "auto", "-summary", "f")
+	if line := strings.SplitN(r.stderr, "\n", 2)[0]; strings.HasSuffix(line, "\tgzip\tauto") == false {
+		t.Errorf("summary = %q, want gzip marked auto", line)
+	}
+	r = mustRun(t, dir, "-k", "-f", "-a", "auto", "-log-format", "json", "f")
+	var rec fileResult
+	if err := json.Unmarshal([]byte(strings.SplitN(r.stderr, "\n", 2)[0]), &rec); err != nil || rec.Algorithm != "gzip" || rec.Auto == false {
+		t.Errorf("log record = %q, %v, want gzip with auto set", r.stderr, err)
+	}
+	r = mustRun(t, dir, "-k", "-f", "-a", "gzip", "-summary", "f")
+	if strings.Contains(r.stderr, "auto") {
+		t.Errorf("summary of -a gzip = %q, want no auto mark", r.stderr)
+	}
+}
+
+// TestSummaryRatio checks the ratios of empty and incompressible inputs:
+// no Inf or NaN, and "-", or null in JSON, when a size is zero.
+func TestSummaryRatio(t *testing.T) {
+	dir := t.TempDir()
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	if err := ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "noise"), noise, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-k", "-f", "-summary", "empty"},
+		{"-d", "-k", "-f", "-summary", "empty.gz"},
+		{"-k", "-f", "-summary", "noise"},
+		{"-k", "-f", "-log-format", "json", "empty"},
+	} {
+		r := mustRun(t, dir, args...)
+		if strings.Contains(r.stderr, "Inf") || strings.Contains(r.stderr, "NaN") {
+			t.Errorf("aio %s: summary %q", strings.Join(args, " "), r.stderr)
+		}
+	}
+	r := mustRun(t, dir, "-k", "-f", "-summary", "empty")
+	if fields := strings.Split(strings.SplitN(r.stderr, "\n", 2)[0], "\t"); len(fields) < 5 || fields[2] != "0" || fields[4] != "-" {
+		t.Errorf("summary of an empty file = %q, want 0 in and ratio -", r.stderr)
+	}
+	r = mustRun(t, dir, "-k", "-f", "-log-format", "json", "empty")
+	var rec fileResult
+	if err := json.Unmarshal([]byte(strings.SplitN(r.stderr, "\n", 2)[0]), &rec); err != nil || rec.Ratio != nil {
+		t.Errorf("log record of an empty file = %q, %v, want a null ratio", r.stderr, err)
+	}
+	r = mustRun(t, dir, "-k", "-f", "-log-format", "json", "noise")
+	if err := json.Unmarshal([]byte(strings.SplitN(r.stderr, "\n", 2)[0]), &rec); err != nil || rec.Ratio == nil || *rec.Ratio <= 1 {
+		t.Errorf("log record of noise = %q, %v, want a ratio above 1", r.stderr, err)
+	}
+}
+
+// TestSummaryOutputSize compresses with every codec on several cores and
+// checks that the output size the summary reports, read once the copies
+// are done, is the size of the file. Under go test -race the command
+// runs with the race detector too, and fails on a race.
+func TestSummaryOutputSize(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(1 << 20)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, algo := range codecNames() {
+		r := mustRun(t, dir, "-k", "-f", "-a", algo, "-o", "out", "-cores", "4", "-verify", "-checksum", "crc32", "-summary", "-show-crc", "f")
+		fi, err := os.Stat(filepath.Join(dir, "out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Split(strings.SplitN(r.stderr, "\n", 2)[0], "\t")
+		if len(fields) < 4 || fields[2] != fmt.Sprint(len(in)) || fields[3] != fmt.Sprint(fi.Size()) {
+			t.Errorf("-a %s: summary %q, want %d bytes in and %d out", algo, r.stderr, len(in), fi.Size())
+		}
+	}
+}
+
+// TestSummaryFailed checks that a run ending on an error reports its file
+// as failed, followed by the totals.
+func TestSummaryFailed(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.gz"), []byte("not gzip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-summary", "missing"},
+		{"-d", "-k", "-a", "gzip", "-summary", "bad.gz"},
+	} {
+		r := runAio(t, nil, dir, "", args...)
+		name := args[len(args)-1]
+		if r.status == 0 || strings.Contains(r.stderr, "failed\t"+name+"\t") == false || strings.Contains(r.stderr, "total\t1\t") == false {
+			t.Errorf("aio %s: exit status %d, stderr %q, want %s failed and the totals", strings.Join(args, " "), r.status, r.stderr, name)
+		}
+	}
+}
+
+// TestSummaryFiles checks the lines of the modes that take several
+// files: one for each of them, then the totals.
+func TestSummaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat(name+"\n", 100)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mustRun(t, dir, "-k", name)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.gz"), []byte("not gzip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		// want are the leading fields of each file line.
+		want []string
+	}{
+		{[]string{"-c", "a", "b"}, []string{"ok\ta\t200\t", "ok\tb\t200\t"}},
+		{[]string{"-cat", "-keep-going", "a.gz", "bad.gz", "b.gz"}, []string{"ok\ta.gz\t", "failed\tbad.gz\t", "ok\tb.gz\t"}},
+		{[]string{"-identify", "a.gz", "a", "missing"}, []string{"ok\ta.gz\t", "skipped\ta\t", "failed\tmissing\t"}},
+		{[]string{"-grep", "a", "a.gz", "b.gz"}, []string{"ok\ta.gz\t", "ok\tb.gz\t"}},
+		{[]string{"-web-assets", "-f", "-variants", "gz,zst", "a", "a.gz"}, []string{"ok\ta\t200\t", "ok\ta\t200\t", "skipped\ta.gz\t"}},
+	}
+	for _, tt := range tests {
+		r := runAio(t, nil, dir, "", append([]string{"-summary"}, tt.args...)...)
+		lines := strings.Split(strings.TrimSuffix(r.stderr, "\n"), "\n")
+		var got []string
+		for _, line := range lines {
+			if strings.HasPrefix(line, "ok\t") || strings.HasPrefix(line, "failed\t") || strings.HasPrefix(line, "skipped\t") {
+				got = append(got, line)
+			}
+		}
+		ok := len(got) == len(tt.want) && strings.HasPrefix(lines[len(lines)-1], fmt.Sprintf("total\t%d\t", len(tt.want)))
+		for i := 0; ok == true && i < len(got); i++ {
+			ok = strings.HasPrefix(got[i], tt.want[i])
+		}
+		if ok == false {
+			t.Errorf("aio -summary %s: stderr %q, want lines starting %q and the totals", strings.Join(tt.args, " "), r.stderr, tt.want)
+		}
+	}
+}
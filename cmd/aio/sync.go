@@ -0,0 +1,39 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// syncOutput flushes the output written through w to the file p, and the
+// directory entry of p, to stable storage for -sync, so that the input
+// is only removed once the output survives a crash.
+func syncOutput(w interface{}, p string) error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+// syncDir flushes the entries of the directory dir. Windows can't sync
+// a directory, its entries are written through.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
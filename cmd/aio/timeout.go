@@ -0,0 +1,103 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// runCtx is cancelled when -timeout expires; copyBuffer checks it
+	// before every read.
+	runCtx = context.Background()
+	// partialMu guards partialOut, partialSize and kept, set by main
+	// while the timer may abort the run.
+	partialMu sync.Mutex
+	// partialOut is the output being written, removed when the run is
+	// aborted, or "" when there's nothing to remove.
+	partialOut string
+	// partialSize is the size an -append output had before the run, which
+	// it is truncated back to instead of being removed.
+	partialSize int64 = -1
+	// kept is set once the output is complete; the run isn't aborted
+	// anymore then, so the input is removed, or kept, as a whole.
+	kept bool
+)
+
+// startTimeout cancels runCtx after d, and aborts the run then even when
+// no copy is reading, e.g. while blocked on standard input.
+func startTimeout(d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	runCtx = ctx
+	go func() {
+		defer cancel()
+		<-ctx.Done()
+		abort(ctx.Err())
+	}()
+}
+
+// trackPartial records p, the output about to be written, for abort.
+func trackPartial(p string) {
+	size := int64(-1)
+	if *appendOut == true {
+		fi, err := os.Stat(p)
+		if err != nil && os.IsNotExist(err) == false {
+			fatal(err.Error())
+		}
+		size = 0
+		if err == nil {
+			size = fi.Size()
+		}
+	}
+	partialMu.Lock()
+	partialOut, partialSize = p, size
+	partialMu.Unlock()
+}
+
+// keepOutput marks the output as complete, before the input is removed.
+func keepOutput() {
+	partialMu.Lock()
+	partialOut, kept = "", true
+	partialMu.Unlock()
+}
+
+// abort removes the partial output and exits with err, unless the output
+// is already kept, in which case it returns. The timer and the copies in
+// both goroutines may get there; the first one holds partialMu until the
+// process exits.
+func abort(err error) {
+	partialMu.Lock()
+	if kept == true {
+		partialMu.Unlock()
+		return
+	}
+	if partialOut != "" {
+		if partialSize >= 0 {
+			os.Truncate(partialOut, partialSize)
+		} else {
+			removeOutput(partialOut)
+		}
+	}
+	if err == context.DeadlineExceeded {
+		fatalf("error: timed out after %s", *timeout)
+	}
+	fatal(err.Error())
+}
+
+// ctxReader aborts the run on reads once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		abort(err)
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTimeoutAbortsCompression(t *testing.T) {
+	dir := t.TempDir()
+	// xz compresses random data at a few MB/s, far slower than the
+	// timeout allows.
+	data := make([]byte, 32<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	in := filepath.Join(dir, "big")
+	if err := ioutil.WriteFile(in, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-a", "xz", "-timeout", "200ms", "big"},
+		{"-a", "xz", "-timeout", "200ms", "-split", "1M", "big"},
+	} {
+		r := runAio(t, nil, dir, "", args...)
+		if r.status == 0 || strings.Contains(r.stderr, "timed out after 200ms") == false {
+			t.Fatalf("aio %s: exit status %d, stderr %q, want a timeout", strings.Join(args, " "), r.status, r.stderr)
+		}
+		// Under go test -race, the command is built with the detector.
+		if strings.Contains(r.stderr, "DATA RACE") {
+			t.Errorf("aio %s: %s", strings.Join(args, " "), r.stderr)
+		}
+		left, err := filepath.Glob(filepath.Join(dir, "big.*"))
+		if err != nil || len(left) > 0 {
+			t.Errorf("aio %s left %v behind", strings.Join(args, " "), left)
+		}
+		if got, err := ioutil.ReadFile(in); err != nil || bytes.Equal(got, data) == false {
+			t.Fatalf("aio %s didn't keep the input: %v", strings.Join(args, " "), err)
+		}
+	}
+}
+
+func TestTimeoutAppendTruncates(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 32<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	if err := ioutil.WriteFile(filepath.Join(dir, "big"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, dir, "-a", "xz", "-k", "-o", "big.xz", "-")
+	before, err := ioutil.ReadFile(filepath.Join(dir, "big.xz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := runAio(t, nil, dir, "", "-a", "xz", "-k", "-append", "-timeout", "200ms", "big")
+	if r.status == 0 {
+		t.Fatalf("aio -append -timeout succeeded, want a timeout")
+	}
+	after, err := ioutil.ReadFile(filepath.Join(dir, "big.xz"))
+	if err != nil || bytes.Equal(before, after) == false {
+		t.Errorf("big.xz has %d bytes after the timeout, want the %d it had", len(after), len(before))
+	}
+}
+
+func TestTimeoutKeptOutput(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.gz")
+	if err := ioutil.WriteFile(out, []byte("complete"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		partialOut, partialSize, kept = "", -1, false
+	}()
+	trackPartial(out)
+	keepOutput()
+	// Once the output is kept, a late timeout leaves it alone and returns.
+	abort(context.DeadlineExceeded)
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("abort removed the kept output: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err := ioutil.ReadAll(&ctxReader{ctx, strings.NewReader("rest")})
+	if err != nil || string(got) != "rest" {
+		t.Errorf("reading after the output is kept = %q, %v, want rest", got, err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(64 << 10)
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	z.Write(in)
+	z.Close()
+	p := filepath.Join(dir, "f.gz")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(in)
+	if err := verifyOutput(p, sum[:]); err != nil {
+		t.Errorf("verifyOutput of a good output: %v", err)
+	}
+	other := sha256.Sum256(in[1:])
+	if err := verifyOutput(p, other[:]); err == nil {
+		t.Errorf("verifyOutput with another input's hash succeeded")
+	}
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	if err := ioutil.WriteFile(p, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyOutput(p, sum[:]); err == nil {
+		t.Errorf("verifyOutput of a corrupt output succeeded")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(64 << 10)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), in, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, algo := range codecNames() {
+		t.Run(algo, func(t *testing.T) {
+			mustRun(t, dir, "-k", "-f", "-verify", "-a", algo, "-o", "out", "f")
+			r := mustRun(t, dir, "-c", "-d", "-a", algo, "out")
+			if r.stdout != string(in) {
+				t.Errorf("the verified output decodes to %d bytes, want %d", len(r.stdout), len(in))
+			}
+		})
+	}
+	mustRun(t, dir, "-verify", "f")
+	if _, err := os.Stat(filepath.Join(dir, "f")); os.IsNotExist(err) == false {
+		t.Errorf("f was kept after a successful verify: %v", err)
+	}
+}
+
+// TestCorruptTrailer changes a byte of the trailing check of each format
+// that ends in one, and expects -d and -cat to fail naming the check
+// rather than write the data as if it were intact.
+func TestCorruptTrailer(t *testing.T) {
+	dir := t.TempDir()
+	in := sampleText(64 << 10)
+	for _, algo := range []string{"bzip2", "gzip", "xz", "zlib", "zstd"} {
+		data, err := aio.Compress(algo, aio.DefaultLevel, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)-3] ^= 0x55
+		name := "f." + codecs[algo].Suffix
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{{"-d", "-c", name}, {"-cat", name}} {
+			r := runAio(t, nil, dir, "", args...)
+			msg := strings.ToLower(r.stderr)
+			if r.status == 0 || (strings.Contains(msg, "checksum") == false && strings.Contains(msg, "crc") == false) {
+				t.Errorf("aio %s with a corrupt trailer: exit status %d, stderr %q, want a failed check", strings.Join(args, " "), r.status, r.stderr)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// writeWebAssets writes a variant of each file compressed at the best
+// level for every -variants suffix, next to the file, for web servers
+// that serve precompressed assets. The files themselves are kept, and
+// those that are already compressed are skipped. It reports whether any
+// file failed.
+func writeWebAssets(files []string) (failed bool) {
+	for _, file := range files {
+		currentInput = file
+		fi, err := os.Stat(file)
+		if err != nil {
+			warnf("%s", err)
+			printResult(fileResult{Name: file, Status: "failed", Algorithm: "-"})
+			failed = true
+			continue
+		}
+		if fi.Mode().IsRegular() == false {
+			warnf("%s is not a regular file", file)
+			printResult(fileResult{Name: file, Status: "failed", Algorithm: "-"})
+			failed = true
+			continue
+		}
+		if alreadyCompressed(file) {
+			if *dryRun == true {
+				fmt.Printf("would skip %s (already compressed)\n", file)
+			} else {
+				warnf("skipping %s (already compressed)", file)
+				printResult(fileResult{Name: file, Status: "skipped", Algorithm: "-", InSize: fi.Size()})
+			}
+			continue
+		}
+		for _, suffix := range strings.Split(*variants, ",") {
+			algo := suffixAlgorithm(suffix)
+			r := fileResult{Name: file, Status: "ok", Algorithm: algo, Level: resultLevel(algo), InSize: fi.Size()}
+			r.OutSize, err = writeVariant(file, fi.Size(), suffix)
+			if err != nil {
+				warnf("%s: %s", file, err)
+				failed = true
+				r.Status = "failed"
+			}
+			if *dryRun == false {
+				printResult(r)
+			}
+		}
+	}
+	return failed
+}
+
+// writeVariant writes the variant of file for suffix and returns its
+// size.
+func writeVariant(file string, size int64, suffix string) (int64, error) {
+	out := file + "." + suffix
+	algo := suffixAlgorithm(suffix)
+	if _, err := os.Lstat(out); err == nil && *force == false {
+		return 0, fmt.Errorf("%s exists. use force to overwrite", out)
+	}
+	if *dryRun == true {
+		fmt.Printf("would compress %s to %s with %s\n", file, out, algo)
+		return 0, nil
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	w := &countWriter{w: f}
+	z, err := newCompressor(w, algo, size)
+	if err == nil {
+		_, err = copyBuffer(z, in)
+		if cerr := z.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return 0, err
+	}
+	return w.n, nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pedroalbanese/aio"
+)
+
+func TestWebAssets(t *testing.T) {
+	dir := t.TempDir()
+	// Files of different sizes: zstd stores each size in its frame
+	// header, which mustn't leak from one file to the next.
+	files := map[string][]byte{
+		"a.html": bytes.Repeat([]byte("<p>a</p>\n"), 500),
+		"b.html": bytes.Repeat([]byte("<p>b</p>\n"), 1000),
+		"c.css":  bytes.Repeat([]byte("p { }\n"), 10),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, dir, "-web-assets", "-a", "zstd", "-variants", "br,gz,zst", "a.html", "b.html", "c.css")
+	for name, data := range files {
+		for suffix, algo := range map[string]string{"br": "brotli", "gz": "gzip", "zst": "zstd"} {
+			z, err := ioutil.ReadFile(filepath.Join(dir, name+"."+suffix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := aio.Decompress(algo, z)
+			if err != nil || bytes.Equal(got, data) == false {
+				t.Errorf("%s.%s doesn't decode to %s: %v", name, suffix, name, err)
+			}
+		}
+		if got, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || bytes.Equal(got, data) == false {
+			t.Errorf("%s wasn't kept: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// windowInput returns r restricted to the window of -skip-bytes and
+// -read-bytes. A regular file is read through a section of it; other
+// inputs, such as standard input or a split set, have the skipped bytes
+// read and discarded.
+func windowInput(r io.Reader) (io.Reader, error) {
+	n := int64(math.MaxInt64) - skipBytes
+	if *readFlag != "" {
+		n = readBytes
+	}
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return io.NewSectionReader(f, skipBytes, n), nil
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, skipBytes); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.LimitReader(r, n), nil
+}
+
+// windowSize returns how much of an input of size bytes the window of
+// -skip-bytes and -read-bytes covers.
+func windowSize(size int64) int64 {
+	size -= skipBytes
+	if size < 0 {
+		size = 0
+	}
+	if *readFlag != "" && readBytes < size {
+		size = readBytes
+	}
+	return size
+}
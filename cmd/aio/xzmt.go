@@ -0,0 +1,100 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pedroalbanese/xz"
+)
+
+// xzParallelWriter compresses blocks of the input, three times the
+// dictionary like xz -T, into independent xz streams on up to workers
+// goroutines and writes them in order. The concatenated streams, each
+// with its own index, are a standard xz file that every decoder reads as
+// one; the ratio drops slightly as matches can't span blocks.
+type xzParallelWriter struct {
+	w       io.Writer
+	config  xz.WriterConfig
+	workers int
+	buf     []byte
+	pending []chan xzBlock
+	blocks  int
+}
+
+type xzBlock struct {
+	data []byte
+	err  error
+}
+
+func newXzParallelWriter(w io.Writer, config xz.WriterConfig, workers int) *xzParallelWriter {
+	return &xzParallelWriter{w: w, config: config, workers: workers, buf: make([]byte, 0, 3*config.DictCap)}
+}
+
+func (x *xzParallelWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		c := copy(x.buf[len(x.buf):cap(x.buf)], p)
+		x.buf = x.buf[:len(x.buf)+c]
+		n += c
+		p = p[c:]
+		if len(x.buf) == cap(x.buf) {
+			if err = x.flushBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flushBlock starts compressing the buffered input, first writing the
+// oldest block when every worker is busy.
+func (x *xzParallelWriter) flushBlock() error {
+	if len(x.pending) == x.workers {
+		if err := x.writeOldest(); err != nil {
+			return err
+		}
+	}
+	done := make(chan xzBlock, 1)
+	go func(in []byte) {
+		var out bytes.Buffer
+		z, err := x.config.NewWriter(&out)
+		if err == nil {
+			_, err = z.Write(in)
+			if cerr := z.Close(); err == nil {
+				err = cerr
+			}
+		}
+		done <- xzBlock{out.Bytes(), err}
+	}(x.buf)
+	x.pending = append(x.pending, done)
+	x.blocks++
+	x.buf = make([]byte, 0, 3*x.config.DictCap)
+	return nil
+}
+
+func (x *xzParallelWriter) writeOldest() error {
+	b := <-x.pending[0]
+	x.pending = x.pending[1:]
+	if b.err != nil {
+		return b.err
+	}
+	_, err := x.w.Write(b.data)
+	return err
+}
+
+// Close compresses the rest of the input and writes the pending blocks.
+func (x *xzParallelWriter) Close() error {
+	if len(x.buf) > 0 || x.blocks == 0 {
+		if err := x.flushBlock(); err != nil {
+			return err
+		}
+	}
+	for len(x.pending) > 0 {
+		if err := x.writeOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
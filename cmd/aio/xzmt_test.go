@@ -0,0 +1,41 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pedroalbanese/xz"
+)
+
+// BenchmarkXzCores compresses 12 MiB at the fast level, in blocks of
+// 3 MiB, with the workers -cores would start. The parallel writer lives in
+// the command, so the benchmark does too rather than with the package's.
+func BenchmarkXzCores(b *testing.B) {
+	in := sampleText(12 << 20)
+	config := xz.WriterConfig{DictCap: xzDictCaps[1]}
+	for _, cores := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("cores=%d", cores), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				var z io.WriteCloser = newXzParallelWriter(ioutil.Discard, config, cores)
+				if cores == 1 {
+					var err error
+					if z, err = config.NewWriter(ioutil.Discard); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := z.Write(in); err != nil {
+					b.Fatal(err)
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
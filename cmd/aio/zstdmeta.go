@@ -0,0 +1,103 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// metaFrameMagic marks the skippable frame -embed-meta writes ahead
+	// of a zstd stream. zstd decoders skip it like any skippable frame.
+	metaFrameMagic = 0x184d2a5a
+	// maxMetaSize bounds the metadata read back from a frame.
+	maxMetaSize = 64 << 10
+)
+
+// fileMeta is the metadata of the original file stored by -embed-meta,
+// the zstd counterpart of the gzip header name and modification time.
+type fileMeta struct {
+	Name  string `json:"name"`
+	MTime int64  `json:"mtime"`
+}
+
+// writeMeta writes m as a skippable frame.
+func writeMeta(w io.Writer, m *fileMeta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var h [8]byte
+	binary.LittleEndian.PutUint32(h[0:], metaFrameMagic)
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(data)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readMeta returns the metadata at the start of the zstd file at p, or
+// nil when there is none. A name that isn't a plain file name is dropped.
+func readMeta(p string) *fileMeta {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var h [8]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil || binary.LittleEndian.Uint32(h[0:]) != metaFrameMagic {
+		return nil
+	}
+	n := binary.LittleEndian.Uint32(h[4:])
+	if n > maxMetaSize {
+		return nil
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil
+	}
+	var m fileMeta
+	if json.Unmarshal(data, &m) != nil {
+		return nil
+	}
+	if m.Name != path.Base(m.Name) || m.Name == "." || m.Name == ".." || m.Name == "/" {
+		m.Name = ""
+	}
+	return &m
+}
+
+// originalName returns the name of the file the input at p holds: its
+// own name, or with -recompress, its name without the compression suffix.
+func originalName(p string) string {
+	name := path.Base(p)
+	if ext := path.Ext(name); *recompress != "" && suffixAlgorithm(strings.TrimPrefix(ext, ".")) != "" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+func inputModTime(p string) int64 {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0
+	}
+	return fi.ModTime().Unix()
+}
+
+// restoreModTime sets the modification time of p to the one stored in m,
+// if any.
+func restoreModTime(p string, m *fileMeta) error {
+	if m.MTime == 0 {
+		return nil
+	}
+	t := time.Unix(m.MTime, 0)
+	return os.Chtimes(p, t, t)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+
+package aio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pedroalbanese/brotli"
+	"github.com/pedroalbanese/lzma"
+	"github.com/pedroalbanese/xz"
+)
+
+// A Codec reads and writes one compression format. Register adds a codec,
+// which Compress, Decompress and the aio command then accept by name. The
+// command builds on the registered codecs, adding the writer options its
+// flags select to the built-in ones. Magic numbers stay in Identify, where
+// the order the formats are tried in matters, so DetectAlgorithm only
+// recognizes the built-in formats.
+type Codec struct {
+	// Name is the algorithm name accepted by Compress and Decompress.
+	Name string
+	// Suffix is the suffix of compressed files, without the dot, and
+	// Extensions are the other suffixes files of the format go by.
+	Suffix     string
+	Extensions []string
+	// MinLevel and MaxLevel bound the levels NewWriter accepts, on the
+	// scale of the algorithm's library. MaxLevel is 0 for algorithms
+	// without levels, which ignore the level.
+	MinLevel, MaxLevel int
+	// NewWriter returns a writer compressing into w at level, or at the
+	// algorithm's default for DefaultLevel.
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader returns a reader decompressing r. Close releases the
+	// decoder without closing r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = map[string]Codec{}
+
+// Register adds c, replacing the codec of the same name if there is one.
+// It is meant to be called from init functions, before any codec is
+// used.
+func Register(c Codec) {
+	codecs[c.Name] = c
+}
+
+// Lookup returns the codec of algo, and whether aio supports it.
+func Lookup(algo string) (Codec, bool) {
+	c, ok := codecs[algo]
+	return c, ok
+}
+
+// Algorithms returns the names of the supported algorithms in order.
+func Algorithms() []string {
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// xzDictCaps are the dictionary sizes of the xz presets 0 to 9. The
+// library has no presets of its own, and the dictionary is what sets the
+// xz presets apart the most.
+var xzDictCaps = [...]int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// xzDictCap returns the xz dictionary size of level, 0 to 9 as the
+// presets of xz, or of the default preset 6 for DefaultLevel. It panics
+// for any other level; newWriter checks the level first.
+func xzDictCap(level int) int {
+	if level == DefaultLevel {
+		level = 6
+	}
+	return xzDictCaps[level]
+}
+
+// wrapEmpty returns z, a writer compressing into w with algo, made to
+// write a valid stream when it is closed without any data. The s2 encoder
+// writes nothing at all for an empty input, not even the stream
+// identifier s2 and snappy streams start with. z is returned as is for
+// the other algorithms.
+func wrapEmpty(algo string, w io.Writer, z io.WriteCloser) io.WriteCloser {
+	switch algo {
+	case "s2":
+		return &emptyStreamWriter{WriteCloser: z, w: w, empty: magicS2}
+	case "snappy":
+		return &emptyStreamWriter{WriteCloser: z, w: w, empty: magicSnappy}
+	}
+	return z
+}
+
+// emptyStreamWriter writes empty to w on Close when no data was written.
+type emptyStreamWriter struct {
+	io.WriteCloser
+	w       io.Writer
+	empty   []byte
+	written bool
+}
+
+func (e *emptyStreamWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		e.written = true
+	}
+	return e.WriteCloser.Write(p)
+}
+
+func (e *emptyStreamWriter) Close() error {
+	if err := e.WriteCloser.Close(); err != nil || e.written == true {
+		return err
+	}
+	_, err := e.w.Write(e.empty)
+	return err
+}
+
+func newWriter(algo string, level int, w io.Writer) (io.WriteCloser, error) {
+	c, ok := codecs[algo]
+	if ok == false {
+		return nil, ErrUnknownAlgorithm
+	}
+	if c.MaxLevel != 0 && level != DefaultLevel && (level < c.MinLevel || level > c.MaxLevel) {
+		return nil, fmt.Errorf("aio: %s level %d out of range %d-%d", algo, level, c.MinLevel, c.MaxLevel)
+	}
+	return c.NewWriter(w, level)
+}
+
+func newReader(algo string, r io.Reader) (io.ReadCloser, error) {
+	c, ok := codecs[algo]
+	if ok == false {
+		return nil, ErrUnknownAlgorithm
+	}
+	return c.NewReader(r)
+}
+
+func init() {
+	Register(Codec{
+		Name:     "brotli",
+		Suffix:   "br",
+		MinLevel: brotli.BestSpeed,
+		MaxLevel: brotli.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == DefaultLevel {
+				level = brotli.DefaultCompression
+			}
+			return brotli.NewWriterLevel(w, level), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			src := &probeReader{r: r}
+			return ioutil.NopCloser(&brotliReader{z: brotli.NewReader(src), src: src}), nil
+		},
+	})
+	Register(Codec{
+		Name:     "bzip2",
+		Suffix:   "bz2",
+		MinLevel: bzip2.BestSpeed,
+		MaxLevel: bzip2.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == DefaultLevel {
+				level = bzip2.DefaultCompression
+			}
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return bzip2.NewReader(r, nil)
+		},
+	})
+	Register(Codec{
+		Name:     "gzip",
+		Suffix:   "gz",
+		MinLevel: gzip.NoCompression,
+		MaxLevel: gzip.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+	Register(Codec{
+		Name:     "lzma",
+		Suffix:   "lzma",
+		MinLevel: lzma.BestSpeed,
+		MaxLevel: lzma.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == DefaultLevel {
+				level = lzma.DefaultCompression
+			}
+			return lzma.NewWriterLevel(w, level), nil
+		},
+		NewReader: newLzmaReader,
+	})
+	Register(Codec{
+		Name:   "s2",
+		Suffix: "s2",
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return wrapEmpty("s2", w, s2.NewWriter(w)), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(s2.NewReader(r)), nil
+		},
+	})
+	Register(Codec{
+		Name:   "snappy",
+		Suffix: "sz",
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return wrapEmpty("snappy", w, s2.NewWriter(w, s2.WriterSnappyCompat())), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(s2.NewReader(r)), nil
+		},
+	})
+	Register(Codec{
+		Name:     "xz",
+		Suffix:   "xz",
+		MinLevel: 0,
+		MaxLevel: len(xzDictCaps) - 1,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return xz.WriterConfig{DictCap: xzDictCap(level)}.NewWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			z, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(z), nil
+		},
+	})
+	Register(Codec{
+		Name:     "zlib",
+		Suffix:   "zz",
+		MinLevel: zlib.NoCompression,
+		MaxLevel: zlib.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	})
+	Register(Codec{
+		Name:       "zstd",
+		Suffix:     "zst",
+		Extensions: []string{"zstd"},
+		MinLevel:   1,
+		MaxLevel:   22,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			l := zstd.SpeedDefault
+			if level != DefaultLevel {
+				l = zstd.EncoderLevelFromZstd(level)
+			}
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(l), zstd.WithZeroFrames(true))
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			z, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return z.IOReadCloser(), nil
+		},
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+
+package aio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultLevel selects the default level of each algorithm.
+const DefaultLevel = -1
+
+// ErrUnknownAlgorithm is returned for an algorithm name aio doesn't
+// support.
+var ErrUnknownAlgorithm = errors.New("aio: unknown algorithm")
+
+// Compress returns data compressed with algo. level is on the scale of
+// the algorithm's library: 0-9 for gzip and zlib, 1-9 for bzip2 and lzma,
+// 0-11 for brotli and 1-22 for zstd, which maps it onto its own levels.
+// xz takes the dictionary sizes of the xz presets 0-9. s2 and snappy have
+// no levels and ignore it. Lookup returns the bounds of each algorithm.
+func Compress(algo string, level int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data)/2 + 64)
+	w, err := newWriter(algo, level, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the decompressed contents of data. When algo is
+// empty, the algorithm is detected as in DetectAlgorithm.
+//
+// Every algorithm returns an error on malformed data rather than
+// panicking, so Decompress may be used on untrusted input. The output size
+// is not bounded, however, and lzma and xz allocate the dictionary the
+// stream declares, up to 512 MB for lzma and 4 GB for xz.
+func Decompress(algo string, data []byte) ([]byte, error) {
+	r, err := DecompressReader(algo, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	buf.Grow(len(data) * 3)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressReader returns a reader streaming the decompressed contents
+// of r. When algo is empty, the algorithm is detected as in
+// DetectAlgorithm. A corrupt or truncated stream makes Read fail rather
+// than end early, and Close releases the decoder without closing r.
+func DecompressReader(algo string, r io.Reader) (io.ReadCloser, error) {
+	if algo == "" {
+		_, z, err := DetectAlgorithm(r)
+		return z, err
+	}
+	return newReader(algo, r)
+}
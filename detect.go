@@ -0,0 +1,203 @@
+// Copyright (c) 2021, Pedro Albanese. All rights reserved.
+// Use of this source code is governed by a ISC license that
+// can be found in the LICENSE file.
+
+// Package aio holds the codecs and the format detection shared by the aio
+// command-line tool.
+package aio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/pedroalbanese/brotli"
+	"github.com/pedroalbanese/lzma"
+)
+
+// ErrUnknownFormat is returned by DetectAlgorithm when the header doesn't
+// match any supported format. Brotli streams carry no magic bytes and are
+// always reported as unknown.
+var ErrUnknownFormat = errors.New("aio: unknown compression format")
+
+// errLzmaDict is returned for an lzma header declaring a dictionary
+// larger than maxLzmaDict.
+var errLzmaDict = errors.New("aio: lzma dictionary too large")
+
+// maxLzmaDict is the largest lzma dictionary accepted, the one written by
+// the lzma encoder at its best level. The decoder allocates whatever the
+// header declares, so a corrupt header could otherwise exhaust memory.
+const maxLzmaDict = 1 << 29
+
+// HeaderSize is the number of bytes Identify needs to recognize a stream.
+const HeaderSize = 13
+
+var (
+	magicGzip   = []byte{0x1f, 0x8b}
+	magicBzip2  = []byte("BZh")
+	magicXz     = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicS2     = []byte("\xff\x06\x00\x00S2sTwO")
+	magicSnappy = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// DetectAlgorithm buffers the header of r, identifies its compression
+// format and returns the algorithm name together with a decompressing
+// reader positioned at the start of the stream. When the magic bytes
+// match but the header is invalid, the algorithm is returned along with
+// the error.
+func DetectAlgorithm(r io.Reader) (algo string, wrapped io.ReadCloser, err error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(HeaderSize)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	algo = Identify(header)
+	if algo == "" {
+		return "", nil, ErrUnknownFormat
+	}
+	wrapped, err = newReader(algo, br)
+	if err != nil {
+		return algo, nil, err
+	}
+	return algo, wrapped, nil
+}
+
+// Identify returns the algorithm whose magic bytes start h, or "" when
+// none matches.
+func Identify(h []byte) string {
+	switch {
+	case bytes.HasPrefix(h, magicGzip):
+		return "gzip"
+	case bytes.HasPrefix(h, magicBzip2):
+		return "bzip2"
+	case bytes.HasPrefix(h, magicXz):
+		return "xz"
+	case bytes.HasPrefix(h, magicZstd), isZstdSkippable(h):
+		return "zstd"
+	case bytes.HasPrefix(h, magicS2):
+		return "s2"
+	case bytes.HasPrefix(h, magicSnappy):
+		return "snappy"
+	case isZlib(h):
+		return "zlib"
+	case isLzma(h):
+		return "lzma"
+	}
+	return ""
+}
+
+// isZstdSkippable reports whether h starts with a zstd skippable frame,
+// which may precede the data frames of a zstd stream.
+func isZstdSkippable(h []byte) bool {
+	return len(h) >= 4 && h[0]&0xf0 == 0x50 && bytes.Equal(h[1:4], []byte{0x2a, 0x4d, 0x18})
+}
+
+// isZlib reports whether h starts with a deflate CMF/FLG pair (RFC 1950).
+func isZlib(h []byte) bool {
+	if len(h) < 2 {
+		return false
+	}
+	return h[0]&0x0f == 8 && h[0]>>4 <= 7 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
+
+// isLzma reports whether h looks like an lzma-alone header: the default
+// lc/lp/pb properties byte followed by a power of two dictionary size.
+func isLzma(h []byte) bool {
+	if len(h) < 5 || h[0] != 0x5d {
+		return false
+	}
+	dict := binary.LittleEndian.Uint32(h[1:5])
+	return dict >= 1<<12 && dict&(dict-1) == 0
+}
+
+// newLzmaReader checks the dictionary size in the lzma header of r
+// before handing the stream to the decoder. The decoder runs in a
+// goroutine of its own, where a failed allocation can't be recovered.
+func newLzmaReader(r io.Reader) (io.ReadCloser, error) {
+	h := make([]byte, 5)
+	if _, err := io.ReadFull(r, h); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(h[1:5]) > maxLzmaDict {
+		return nil, errLzmaDict
+	}
+	return lzma.NewReader(io.MultiReader(bytes.NewReader(h), truncatedReader{r})), nil
+}
+
+// truncatedReader reports the end of r as io.ErrUnexpectedEOF. The lzma
+// decoder takes the end of its input for the end of the stream, while it
+// only reads past the data of a complete stream when it is truncated.
+type truncatedReader struct {
+	r io.Reader
+}
+
+func (t truncatedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// brotliReader reports a brotli stream that ends before its last
+// meta-block as truncated. The brotli reader returns io.EOF whenever its
+// input ends, so at that point the stream is probed with one more byte:
+// a complete stream rejects it as excessive input.
+type brotliReader struct {
+	z   *brotli.Reader
+	src *probeReader
+	err error
+}
+
+// brotliExcessiveInput is the message of the error the brotli reader
+// returns for data after the end of the stream. The library doesn't
+// export the error, so TestBrotliExcessiveInput pins the wording.
+const brotliExcessiveInput = "brotli: excessive input"
+
+func (b *brotliReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.z.Read(p)
+	if err == io.EOF && b.src.eof == true {
+		b.src.probe = true
+		var buf [1]byte
+		if _, perr := b.z.Read(buf[:]); perr == nil || perr.Error() != brotliExcessiveInput {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// probeReader reads r and, once r has ended and probe is set, returns a
+// single zero byte before ending again.
+type probeReader struct {
+	r     io.Reader
+	eof   bool
+	probe bool
+}
+
+func (p *probeReader) Read(b []byte) (int, error) {
+	if p.eof == false {
+		n, err := p.r.Read(b)
+		if err == io.EOF {
+			p.eof = true
+		}
+		return n, err
+	}
+	if p.probe == true && len(b) > 0 {
+		p.probe = false
+		b[0] = 0
+		return 1, nil
+	}
+	return 0, io.EOF
+}